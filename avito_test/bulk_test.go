@@ -0,0 +1,234 @@
+package main
+
+import (
+	"avito_test/config"
+	"avito_test/jobs"
+	"avito_test/model"
+	"avito_test/storage"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newBulkRequest(seller string, body string) *http.Request {
+	return httptest.NewRequest("POST", "/offers/bulk?seller="+seller, strings.NewReader(body))
+}
+
+func decodeJob(t *testing.T, rr *httptest.ResponseRecorder) *model.Job {
+	job := &model.Job{}
+	if err := json.Unmarshal(rr.Body.Bytes(), job); err != nil {
+		t.Fatalf("error in decoding %q: %v", rr.Body.String(), err)
+	}
+	return job
+}
+
+func TestBulkOffersSync(t *testing.T) {
+	m, c := newImportServer(t)
+	seedProducts(t, c.Store,
+		&model.Product{SellerId: 1, OfferId: 3, Name: "old", Price: 100, Currency: "RUB", Quantity: 1},
+		&model.Product{SellerId: 1, OfferId: 4, Name: "kept", Price: 100, Currency: "RUB", Quantity: 1},
+	)
+
+	rr := serve(m, newBulkRequest("1", `[
+		{"offer_id": 1, "name": "apple", "price": "1299.90", "quantity": 5, "available": true, "category": ["Фрукты", "Яблоки"]},
+		{"offer_id": 2, "name": "pear", "price": 10, "currency": "usd", "quantity": 1, "available": true},
+		{"offer_id": 3, "available": false},
+		{"offer_id": 4, "name": "kept", "price": "-1", "quantity": 1, "available": true},
+		{"offer_id": 5, "name": "no flag", "price": "1", "quantity": 1},
+		{"offer_id": 6, "name": "wrong price", "price": "1,5", "quantity": 1, "available": true},
+		{"offer_id": 7, "seller_id": 2, "name": "other seller", "price": "1", "quantity": 1, "available": true},
+		{"offer_id": 2147483648, "available": false}
+	]`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v, %v", rr.Code, rr.Body.String())
+	}
+	errors := []string{
+		"offer 4: price lower than zero",
+		"offer 5: available is not set",
+		`offer 6: invalid offer: parsing "1,5": invalid decimal`,
+		"offer 7: seller id 2 differs from seller 1",
+		"offer 8: offer id is too large",
+	}
+	job := decodeJob(t, rr)
+	if job.Status != finishedStatus(2, 1, errors...) || !reflect.DeepEqual(job.Errors, errors) || job.Progress.ProcessedRows != 8 {
+		t.Errorf("got result %+v, %q", job, job.Status)
+	}
+
+	expected := []*model.Product{
+		{SellerId: 1, OfferId: 1, Name: "apple", Price: 129990, Currency: "RUB", Quantity: 5, Category: []string{"Фрукты", "Яблоки"}},
+		{SellerId: 1, OfferId: 2, Name: "pear", Price: 1000, Currency: "USD", Quantity: 1},
+		{SellerId: 1, OfferId: 4, Name: "kept", Price: 100, Currency: "RUB", Quantity: 1},
+	}
+	for _, product := range expected {
+		product.Available, product.CreatedAt, product.UpdatedAt = true, testNow, testNow
+	}
+	if products := sellerProducts(t, c, 1); !reflect.DeepEqual(products, expected) {
+		t.Errorf("got products %+v want %+v", products, expected)
+	}
+}
+
+// TestBulkOffersNDJSON проверяет, что испорченная строка NDJSON отклоняет только своё предложение
+func TestBulkOffersNDJSON(t *testing.T) {
+	m, c := newImportServer(t)
+
+	rr := serve(m, newBulkRequest("1", `{"offer_id": 1, "name": "first", "price": "1", "quantity": 1, "available": true}
+
+{"offer_id": 2, "name": broken}
+{"offer_id": 1, "name": "last", "price": "2", "quantity": 2, "available": true}
+`))
+	job := decodeJob(t, rr)
+	expected := finishedStatus(1, 0,
+		"offer 1: offer id 1 is duplicated, last occurrence at offer 3 is used",
+		"offer 2: invalid offer: invalid character 'b' looking for beginning of value",
+	)
+	if rr.Code != http.StatusOK || job.Status != expected {
+		t.Errorf("got %v, %q want %q", rr.Code, job.Status, expected)
+	}
+	if products := sellerProducts(t, c, 1); len(products) != 1 || products[0].Name != "last" {
+		t.Errorf("got products %+v want last occurrence", products)
+	}
+}
+
+func TestBulkOffersAsync(t *testing.T) {
+	cfg := config.Default()
+	cfg.TempDir = t.TempDir()
+	cfg.StorageDir = t.TempDir()
+	cfg.BatchSize = 2
+	cfg.BulkSyncOffers = 2
+	m, c := newTestServer(t, cfg)
+
+	offers := []string{}
+	for _, row := range [][]string{{"1", "a"}, {"2", "b"}, {"3", "c"}, {"4", "d"}, {"5", "e"}} {
+		offers = append(offers, `{"offer_id":`+row[0]+`,"name":"`+row[1]+`","price":"1","quantity":1,"available":true}`)
+	}
+	rr := serve(m, newBulkRequest("1", "["+strings.Join(offers, ",")+"]"))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("got %v, %v want 202", rr.Code, rr.Body.String())
+	}
+	job, ok := c.Jobs.GetForSeller(decodeJob(t, rr).Id, 1)
+	if !ok {
+		t.Fatalf("job is not registered: %v", rr.Body.String())
+	}
+	select {
+	case <-job.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("job %v did not finish, status: %v", job.Id, job.Status())
+	}
+	if job.Status() != finishedStatus(5, 0) {
+		t.Errorf("got status %q", job.Status())
+	}
+	checkpoints, err := c.Store.Checkpoints(context.Background(), job.Id)
+	if err != nil || len(checkpoints) != 3 {
+		t.Errorf("got %v checkpoints, %v want 3 batches", len(checkpoints), err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(cfg.StorageDir, "*")); len(matches) != 0 {
+		t.Errorf("stored body is not deleted: %v", matches)
+	}
+}
+
+func TestBulkOffersRejectsBody(t *testing.T) {
+	m, c := newImportServer(t)
+	for body, expected := range map[string]string{
+		"":                         "request body has no offers",
+		"  \n":                     "request body has no offers",
+		`[{"offer_id": 1}`:         "error in parsing offer 2: unexpected end of JSON input",
+		`[{"offer_id": 1}, oops]`:  "error in parsing offer 2: invalid character 'o' looking for beginning of value",
+		`[{"offer_id": 1}] extra`:  "error in parsing offers: unexpected data after array",
+		`[{"offer_id": 1}] [1, 2]`: "error in parsing offers: unexpected data after array",
+	} {
+		rr := serve(m, newBulkRequest("1", body))
+		if rr.Code != http.StatusInternalServerError || rr.Body.String() != expected {
+			t.Errorf("%q: got %v, %q want %q", body, rr.Code, rr.Body.String(), expected)
+		}
+	}
+	if rr := serve(m, newBulkRequest("one", "[]")); rr.Code != http.StatusInternalServerError {
+		t.Errorf("incorrect seller: got %v, %v", rr.Code, rr.Body.String())
+	}
+	if matches, _ := filepath.Glob(filepath.Join(c.Config.StorageDir, "*")); len(matches) != 0 {
+		t.Errorf("rejected bodies are not deleted: %v", matches)
+	}
+}
+
+// TestBulkOffersFormContentType проверяет тело, которое curl -d отправляет как application/x-www-form-urlencoded
+func TestBulkOffersFormContentType(t *testing.T) {
+	m, c := newImportServer(t)
+	req := newBulkRequest("1", `{"offer_id": 1, "name": "apple", "price": "1", "quantity": 1, "available": true}`)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := serve(m, req)
+	if rr.Code != http.StatusOK || decodeJob(t, rr).Status != finishedStatus(1, 0) {
+		t.Errorf("got %v, %v", rr.Code, rr.Body.String())
+	}
+	if products := sellerProducts(t, c, 1); len(products) != 1 || products[0].Name != "apple" {
+		t.Errorf("got products %+v", products)
+	}
+}
+
+func TestBulkOffersLargerThanLimit(t *testing.T) {
+	m, c := newImportServer(t)
+	c.Config.MaxUploadSize = 1024
+
+	rr := serve(m, newBulkRequest("1", "["+strings.Repeat(`{"offer_id": 1},`, 100)+"]"))
+	if rr.Code != http.StatusRequestEntityTooLarge || rr.Body.String() != "upload is larger than 1024 bytes" {
+		t.Errorf("got %v, %v", rr.Code, rr.Body.String())
+	}
+	if matches, _ := filepath.Glob(filepath.Join(c.Config.TempDir, "*")); len(matches) != 0 {
+		t.Errorf("temp file is not deleted: %v", matches)
+	}
+}
+
+// TestBulkOffersResume проверяет, что задача POST /offers/bulk после падения продолжается с незакоммиченной пачки
+func TestBulkOffersResume(t *testing.T) {
+	cfg := config.Default()
+	cfg.StorageDir = t.TempDir()
+	cfg.BatchSize = 2
+	store := storage.NewMemory()
+	ctx := context.Background()
+
+	filePath := filepath.Join(cfg.StorageDir, "bulk-job.json")
+	body := `{"offer_id": 1, "name": "committed", "price": "1", "quantity": 1, "available": true}
+{"offer_id": 2, "name": "committed", "price": "1", "quantity": 1, "available": true}
+{"offer_id": 3, "name": "new", "price": "1", "quantity": 1, "available": true}`
+	if err := ioutil.WriteFile(filePath, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertJob(ctx, &storage.StoredJob{Id: "bulk-job", SellerId: 1, FilePath: filePath, BatchSize: 2}, "working with offers"); err != nil {
+		t.Fatal(err)
+	}
+	//первая пачка была закоммичена до падения, её товары повторно не пишутся
+	err := store.SaveBatch(ctx, "bulk-job", jobs.BatchKey{}, &storage.Batch{SellerId: 1, Upsert: []*model.Product{
+		{OfferId: 1, Name: "before crash", Price: 100, Currency: "RUB", Quantity: 1},
+		{OfferId: 2, Name: "before crash", Price: 100, Currency: "RUB", Quantity: 1},
+	}}, &jobs.Checkpoint{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, c := newServer(store, cfg, testLogger)
+	if err := c.ResumeJobs(); err != nil {
+		t.Fatal(err)
+	}
+	job, ok := c.Jobs.Get("bulk-job")
+	if !ok {
+		t.Fatal("job is not resumed")
+	}
+	select {
+	case <-job.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("job did not finish, status: %v", job.Status())
+	}
+	if job.Status() != finishedStatus(3, 0) {
+		t.Errorf("got status %q", job.Status())
+	}
+	products := sellerProducts(t, c, 1)
+	if len(products) != 3 || products[0].Name != "before crash" || products[1].Name != "before crash" || products[2].Name != "new" {
+		t.Errorf("got products %+v want committed batch untouched", products)
+	}
+}
@@ -0,0 +1,353 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Addr          string   `yaml:"addr"`
+	Database      Database `yaml:"database"`
+	MaxUploadSize int64    `yaml:"max_upload_size"`
+	TempDir       string   `yaml:"temp_dir"`
+	//загруженные файлы хранятся здесь до завершения задачи, чтобы её можно было возобновить после падения
+	StorageDir    string `yaml:"storage_dir"`
+	BatchSize     int    `yaml:"batch_size"`
+	WebhookSecret string `yaml:"webhook_secret"`
+	//сколько ждать завершения запущенных задач при остановке
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	//таймауты основного адреса и админки: чтение заголовков, чтение всего запроса вместе с файлом и простой
+	//keep-alive соединения, 0 - без ограничения. Таймаута записи нет: поток событий задачи и выгрузка товаров
+	//отвечают сколь угодно долго
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	//при таком количестве одновременных задач сервис сообщает, что не готов принимать нагрузку
+	MaxActiveJobs int `yaml:"max_active_jobs"`
+	//debug, info, warn или error
+	LogLevel string `yaml:"log_level"`
+	Admin    Admin  `yaml:"admin"`
+	//применять миграции схемы бд при старте, иначе их запускают командой migrate
+	MigrateOnStart bool `yaml:"migrate_on_start"`
+	//адреса сайтов, которым браузер разрешит обращаться к api, "*" - любым
+	CORSOrigins []string `yaml:"cors_origins"`
+	//какая из строк файла с одинаковым offer id применяется: first, last или ни одна (reject)
+	DuplicateOffers string `yaml:"duplicate_offers"`
+	//POST /offers/bulk с таким количеством предложений или меньше отвечает итогом, а не id задачи
+	BulkSyncOffers int `yaml:"bulk_sync_offers"`
+	//сколько завершённая задача хранится в памяти, дальше её статус отдаётся из бд, 0 - хранится до перезапуска
+	FinishedJobsTTL time.Duration `yaml:"finished_jobs_ttl"`
+}
+
+// политики для строк файла с повторяющимся offer id
+const (
+	DuplicateFirst  = "first"
+	DuplicateLast   = "last"
+	DuplicateReject = "reject"
+)
+
+// Admin настраивает доступ к pprof и странице состояния сервиса
+type Admin struct {
+	//отдельный адрес для админки, пустой - админка на основном порту, если заданы логин и пароль
+	Addr     string `yaml:"addr"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+type Database struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+	//сколько при старте повторять попытки подключения к бд
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+}
+
+// DSN собирает адрес postgres:// - логин и пароль в нём экранируются, поэтому могут содержать пробелы, кавычки и обратный слеш
+func (d Database) DSN() string {
+	dsn := &url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     net.JoinHostPort(d.Host, strconv.Itoa(d.Port)),
+		Path:     "/" + d.Name,
+		RawQuery: url.Values{"sslmode": {d.SSLMode}}.Encode(),
+	}
+	return dsn.String()
+}
+
+func Default() *Config {
+	return &Config{
+		Addr: ":8080",
+		Database: Database{
+			Host:           "localhost",
+			Port:           5432,
+			User:           "root",
+			Password:       "root",
+			Name:           "root",
+			SSLMode:        "disable",
+			ConnectTimeout: time.Minute,
+		},
+		//Max size of file set to 120MB
+		MaxUploadSize:   120 << 20,
+		TempDir:         "temp_files",
+		StorageDir:      "uploads",
+		BatchSize:       100,
+		ShutdownTimeout: 30 * time.Second,
+		//файл в MaxUploadSize по медленному каналу читается минуты
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       10 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		MaxActiveJobs:     20,
+		LogLevel:          "info",
+		Admin: Admin{
+			Addr: "localhost:6060",
+		},
+		MigrateOnStart:  true,
+		DuplicateOffers: DuplicateLast,
+		BulkSyncOffers:  1000,
+		FinishedJobsTTL: time.Hour,
+	}
+}
+
+// Load берёт значения по умолчанию, поверх них файл path (если задан), поверх него переменные окружения
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error in reading config file: %v", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("error in parsing config file: %v", err)
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) loadEnv() error {
+	setString(&cfg.Addr, "SERVER_ADDR")
+	setString(&cfg.Database.Host, "DATABASE_HOST")
+	setString(&cfg.Database.User, "DATABASE_USER")
+	setString(&cfg.Database.Password, "DATABASE_PASS")
+	setString(&cfg.Database.Name, "DATABASE_NAME")
+	setString(&cfg.Database.SSLMode, "DATABASE_SSLMODE")
+	setString(&cfg.TempDir, "TEMP_DIR")
+	setString(&cfg.StorageDir, "STORAGE_DIR")
+	setString(&cfg.WebhookSecret, "WEBHOOK_SECRET")
+	setString(&cfg.LogLevel, "LOG_LEVEL")
+	setString(&cfg.Admin.Addr, "ADMIN_ADDR")
+	setString(&cfg.Admin.User, "ADMIN_USER")
+	setString(&cfg.Admin.Password, "ADMIN_PASSWORD")
+	setString(&cfg.DuplicateOffers, "DUPLICATE_OFFERS")
+
+	if value, ok := os.LookupEnv("CORS_ORIGINS"); ok {
+		cfg.CORSOrigins = nil
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				cfg.CORSOrigins = append(cfg.CORSOrigins, origin)
+			}
+		}
+	}
+
+	if err := setInt(&cfg.Database.Port, "DATABASE_PORT"); err != nil {
+		return err
+	}
+	if err := setInt(&cfg.BatchSize, "BATCH_SIZE"); err != nil {
+		return err
+	}
+	if err := setInt(&cfg.MaxActiveJobs, "MAX_ACTIVE_JOBS"); err != nil {
+		return err
+	}
+	if err := setInt(&cfg.BulkSyncOffers, "BULK_SYNC_OFFERS"); err != nil {
+		return err
+	}
+	if err := setBool(&cfg.MigrateOnStart, "MIGRATE_ON_START"); err != nil {
+		return err
+	}
+	if err := setDuration(&cfg.ShutdownTimeout, "SHUTDOWN_TIMEOUT"); err != nil {
+		return err
+	}
+	if err := setDuration(&cfg.Database.ConnectTimeout, "DATABASE_CONNECT_TIMEOUT"); err != nil {
+		return err
+	}
+	if err := setDuration(&cfg.FinishedJobsTTL, "FINISHED_JOBS_TTL"); err != nil {
+		return err
+	}
+	if err := setDuration(&cfg.ReadHeaderTimeout, "READ_HEADER_TIMEOUT"); err != nil {
+		return err
+	}
+	if err := setDuration(&cfg.ReadTimeout, "READ_TIMEOUT"); err != nil {
+		return err
+	}
+	if err := setDuration(&cfg.IdleTimeout, "IDLE_TIMEOUT"); err != nil {
+		return err
+	}
+	if value, ok := os.LookupEnv("MAX_UPLOAD_SIZE"); ok {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("MAX_UPLOAD_SIZE is not a number: %v", err)
+		}
+		cfg.MaxUploadSize = size
+	}
+	return nil
+}
+
+func setString(field *string, env string) {
+	if value, ok := os.LookupEnv(env); ok {
+		*field = value
+	}
+}
+
+func setInt(field *int, env string) error {
+	if value, ok := os.LookupEnv(env); ok {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%v is not a number: %v", env, err)
+		}
+		*field = number
+	}
+	return nil
+}
+
+func setBool(field *bool, env string) error {
+	if value, ok := os.LookupEnv(env); ok {
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%v is not a boolean: %v", env, err)
+		}
+		*field = flag
+	}
+	return nil
+}
+
+func setDuration(field *time.Duration, env string) error {
+	if value, ok := os.LookupEnv(env); ok {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%v is not a duration: %v", env, err)
+		}
+		*field = duration
+	}
+	return nil
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.Addr == "" {
+		return fmt.Errorf("addr must be set")
+	}
+	//без таймаута заголовков медленный клиент держит соединение сколько угодно
+	if cfg.ReadHeaderTimeout <= 0 {
+		return fmt.Errorf("read header timeout must be positive: %v", cfg.ReadHeaderTimeout)
+	}
+	if cfg.ReadTimeout < 0 || cfg.IdleTimeout < 0 {
+		return fmt.Errorf("read and idle timeouts must not be negative: %v, %v", cfg.ReadTimeout, cfg.IdleTimeout)
+	}
+	if cfg.Database.Host == "" || cfg.Database.User == "" || cfg.Database.Name == "" {
+		return fmt.Errorf("database host, user and name must be set")
+	}
+	if cfg.Database.Port <= 0 || cfg.Database.Port > 65535 {
+		return fmt.Errorf("database port out of range: %v", cfg.Database.Port)
+	}
+	if cfg.MaxUploadSize <= 0 {
+		return fmt.Errorf("max upload size must be positive: %v", cfg.MaxUploadSize)
+	}
+	if cfg.TempDir == "" {
+		return fmt.Errorf("temp dir must be set")
+	}
+	if info, err := os.Stat(cfg.TempDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("temp dir %v is not an existing directory", cfg.TempDir)
+	}
+	if cfg.StorageDir == "" {
+		return fmt.Errorf("storage dir must be set")
+	}
+	if cfg.BatchSize <= 0 {
+		return fmt.Errorf("batch size must be positive: %v", cfg.BatchSize)
+	}
+	if cfg.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown timeout must not be negative: %v", cfg.ShutdownTimeout)
+	}
+	if cfg.Database.ConnectTimeout < 0 {
+		return fmt.Errorf("database connect timeout must not be negative: %v", cfg.Database.ConnectTimeout)
+	}
+	if cfg.MaxActiveJobs <= 0 {
+		return fmt.Errorf("max active jobs must be positive: %v", cfg.MaxActiveJobs)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return fmt.Errorf("unknown log level: %v", cfg.LogLevel)
+	}
+	if (cfg.Admin.User == "") != (cfg.Admin.Password == "") {
+		return fmt.Errorf("admin user and password must be set together")
+	}
+	if cfg.Admin.Addr != "" && cfg.Admin.User == "" {
+		loopback, err := isLoopback(cfg.Admin.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid admin addr %v: %v", cfg.Admin.Addr, err)
+		}
+		//pprof и состояние всех продавцов не должны быть доступны из сети без пароля
+		if !loopback {
+			return fmt.Errorf("admin addr %v is reachable from network, set admin user and password or bind it to localhost", cfg.Admin.Addr)
+		}
+	}
+	switch cfg.DuplicateOffers {
+	case DuplicateFirst, DuplicateLast, DuplicateReject:
+	default:
+		return fmt.Errorf("unknown duplicate offers policy: %v", cfg.DuplicateOffers)
+	}
+	if cfg.BulkSyncOffers < 0 {
+		return fmt.Errorf("bulk sync offers must not be negative: %v", cfg.BulkSyncOffers)
+	}
+	if cfg.FinishedJobsTTL < 0 {
+		return fmt.Errorf("finished jobs ttl must not be negative: %v", cfg.FinishedJobsTTL)
+	}
+	return nil
+}
+
+// isLoopback сообщает, что адрес слушает только локальные соединения. Пустой хост (":6060") - это все интерфейсы
+func isLoopback(addr string) (bool, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false, err
+	}
+	if host == "localhost" {
+		return true, nil
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback(), nil
+}
+
+// String печатает конфиг в формате yaml со скрытыми паролями и секретом
+func (cfg *Config) String() string {
+	masked := *cfg
+	masked.Database.Password = mask(masked.Database.Password)
+	masked.Admin.Password = mask(masked.Admin.Password)
+	masked.WebhookSecret = mask(masked.WebhookSecret)
+	data, err := yaml.Marshal(&masked)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "******"
+}
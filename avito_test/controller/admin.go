@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"avito_test/model"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+type adminStatus struct {
+	Stopping      bool
+	ActiveJobs    int64
+	MaxActiveJobs int
+	Jobs          []*model.SellerJob
+}
+
+// AdminStatus показывает незавершённые задачи всех продавцов
+func (c *Controller) AdminStatus(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	result := &adminStatus{
+		Stopping:      c.isStopping(),
+		ActiveJobs:    atomic.LoadInt64(&c.activeJobs),
+		MaxActiveJobs: c.Config.MaxActiveJobs,
+		Jobs:          []*model.SellerJob{},
+	}
+	for _, job := range c.Jobs.ListActive() {
+		result.Jobs = append(result.Jobs, &model.SellerJob{
+			SellerId: job.SellerId,
+			Job: &model.Job{
+				Id:       job.Id,
+				Status:   job.Status(),
+				Progress: job.Progress.Snapshot(now),
+			},
+		})
+	}
+
+	c.writeJSON(w, 200, result)
+}
@@ -0,0 +1,331 @@
+package controller
+
+import (
+	"avito_test/jobs"
+	"avito_test/logging"
+	"avito_test/model"
+	"avito_test/storage"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bulkExtension - расширение файла, в котором задача POST /offers/bulk хранит тело запроса
+const bulkExtension = ".json"
+
+// bulkOffer - предложение POST /offers/bulk в формате товара из /offers. Флаг available обязателен,
+// как и колонка доступности в файле: без него нельзя понять, обновить товар или удалить
+type bulkOffer struct {
+	model.Product
+	Available *bool `json:"available"`
+}
+
+// bulkEntry - предложение из тела запроса, err - ошибка разбора именно этого предложения
+type bulkEntry struct {
+	raw   []byte
+	offer *bulkOffer
+	err   error
+}
+
+// offerPosition - номер предложения в теле запроса, с нуля
+type offerPosition int
+
+func (p offerPosition) String() string {
+	return fmt.Sprintf("offer %v", int(p)+1)
+}
+
+// ReceiveOffersBulk принимает предложения JSON-массивом или NDJSON и применяет их так же, как строки файла.
+// Небольшой запрос дожидается итога, на большой сразу отвечается 202 с id задачи
+func (c *Controller) ReceiveOffersBulk(w http.ResponseWriter, r *http.Request) {
+	//параметры берутся только из адреса: FormValue прочитал бы тело, которое curl -d отправляет как форму
+	query := r.URL.Query()
+	senderId, err := strconv.ParseInt(query.Get("seller"), 10, 64)
+	if err != nil {
+		logging.FromRequest(c.Logger, r).Warn("error in parsing seller id", "error", err)
+		c.Metrics.Uploads.WithLabelValues("rejected").Inc()
+		writeText(w, 500, err.Error())
+		return
+	}
+	callbackUrl := query.Get("callback_url")
+	if callbackUrl != "" {
+		if err := c.Notifier.ValidateURL(callbackUrl); err != nil {
+			logging.FromRequest(c.Logger, r).Warn("error in parsing callback url", "error", err)
+			c.Metrics.Uploads.WithLabelValues("rejected").Inc()
+			writeText(w, 500, err.Error())
+			return
+		}
+	}
+
+	if !c.startJob() {
+		c.Metrics.Uploads.WithLabelValues("rejected").Inc()
+		writeText(w, 503, "service is shutting down")
+		return
+	}
+	job := c.Jobs.Create(senderId)
+	job.CallbackUrl = callbackUrl
+	job.Logger = logging.FromRequest(c.Logger, r).With("job_id", job.Id, "seller_id", job.SellerId)
+	job.Logger.Info("bulk offers upload started")
+	reject := func(err error) {
+		job.Logger.Warn("bulk offers rejected", "error", err)
+		job.Finish(fmt.Sprintf("error: %v", err.Error()))
+		c.finishRunning()
+		c.Metrics.Uploads.WithLabelValues("rejected").Inc()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeText(w, 413, fmt.Sprintf("upload is larger than %v bytes", tooLarge.Limit))
+			return
+		}
+		writeText(w, 500, err.Error())
+	}
+
+	//тело сохраняется в хранилище, чтобы задачу можно было возобновить после падения, как загрузку файла
+	filePath, err := c.storeUpload(http.MaxBytesReader(w, r.Body, c.Config.MaxUploadSize), job.Id, bulkExtension)
+	if err != nil {
+		reject(err)
+		return
+	}
+	entries, err := readBulkFile(filePath)
+	if err == nil {
+		err = c.Store.InsertJob(c.ctx, &storage.StoredJob{
+			Id:          job.Id,
+			SellerId:    job.SellerId,
+			FilePath:    filePath,
+			CallbackUrl: job.CallbackUrl,
+			BatchSize:   c.Config.BatchSize,
+			CreatedAt:   job.CreatedAt,
+		}, "offers received")
+		if err != nil {
+			err = fmt.Errorf("error in saving job: %v", err)
+		}
+	}
+	if err != nil {
+		if err := os.Remove(filePath); err != nil {
+			job.Logger.Error("error in deleting file", "error", err)
+		}
+		reject(err)
+		return
+	}
+	job.FilePath = filePath
+	job.BatchSize = c.Config.BatchSize
+	job.SetStatus("offers received")
+	job.Logger.Info("bulk offers received", "offers", len(entries))
+	c.Metrics.Uploads.WithLabelValues("accepted").Inc()
+
+	go func() {
+		defer c.finishRunning()
+		c.runImport(job, func() error {
+			return c.applyOffers(job, entries, map[jobs.BatchKey]*jobs.Checkpoint{})
+		})
+	}()
+
+	if len(entries) > c.Config.BulkSyncOffers {
+		c.writeJSON(w, 202, &model.Job{Id: job.Id, Status: job.Status()})
+		return
+	}
+	//клиент, не дождавшийся ответа, может узнать итог по id задачи
+	select {
+	case <-job.Done():
+	case <-r.Context().Done():
+		return
+	}
+	c.writeJSON(w, 200, &model.Job{
+		Id:       job.Id,
+		Status:   job.Status(),
+		Progress: job.Progress.Snapshot(time.Now()),
+		Errors:   job.Progress.ErrorStrings(),
+	})
+}
+
+func readBulkFile(path string) ([]*bulkEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error in opening offers file: %v", err)
+	}
+	defer file.Close()
+	return readBulkOffers(file)
+}
+
+// readBulkOffers разбирает JSON-массив предложений или NDJSON - по предложению на строку. Синтаксическая ошибка
+// в массиве не даёт найти следующие предложения и отклоняет весь запрос, а в NDJSON портит только свою строку
+func readBulkOffers(reader io.Reader) ([]*bulkEntry, error) {
+	buffered := bufio.NewReader(reader)
+	for {
+		b, err := buffered.ReadByte()
+		if err == io.EOF {
+			return nil, fmt.Errorf("request body has no offers")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error in reading offers: %v", err)
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		buffered.UnreadByte()
+		if b == '[' {
+			return readJSONArray(buffered)
+		}
+		return readNDJSON(buffered)
+	}
+}
+
+func readJSONArray(reader io.Reader) ([]*bulkEntry, error) {
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("error in parsing offers: %v", err)
+	}
+	entries := []*bulkEntry{}
+	for decoder.More() {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("error in parsing %v: %v", offerPosition(len(entries)), err)
+		}
+		entries = append(entries, newBulkEntry(raw))
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("error in parsing offers: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("error in parsing offers: unexpected data after array")
+	}
+	return entries, nil
+}
+
+func readNDJSON(reader *bufio.Reader) ([]*bulkEntry, error) {
+	entries := []*bulkEntry{}
+	for {
+		line, err := reader.ReadBytes('\n')
+		//пустые строки не считаются предложениями
+		if line = bytes.TrimSpace(line); len(line) != 0 {
+			entries = append(entries, newBulkEntry(line))
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error in reading offers: %v", err)
+		}
+	}
+}
+
+func newBulkEntry(raw []byte) *bulkEntry {
+	entry := &bulkEntry{raw: raw, offer: &bulkOffer{}}
+	if err := json.Unmarshal(raw, entry.offer); err != nil {
+		entry.offer, entry.err = nil, err
+	}
+	return entry
+}
+
+// parseOffer проверяет предложение по тем же правилам, что и строку файла.
+// Для недоступного товара заполнен только OfferId и available = false
+func parseOffer(entry *bulkEntry, position offerPosition, sellerId int64) (*model.Product, bool, *rejection) {
+	if entry.err != nil {
+		return nil, false, &rejection{"json", fmt.Sprintf("%v: invalid offer: %v", position, entry.err)}
+	}
+	offer := entry.offer
+	if err := model.ValidateOfferId(offer.OfferId); err != nil {
+		return nil, false, &rejection{err.Field, fmt.Sprintf("%v: %v", position, err.Message)}
+	}
+	if offer.SellerId != 0 && offer.SellerId != sellerId {
+		return nil, false, &rejection{"seller_id", fmt.Sprintf("%v: seller id %v differs from seller %v", position, offer.SellerId, sellerId)}
+	}
+	if offer.Available == nil {
+		return nil, false, &rejection{"available", fmt.Sprintf("%v: available is not set", position)}
+	}
+	if !*offer.Available {
+		return &model.Product{OfferId: offer.OfferId}, false, nil
+	}
+
+	product := offer.Product
+	//код валюты в файле принимается в любом регистре, здесь так же
+	product.Currency = strings.ToUpper(product.Currency)
+	if err := product.Validate(); err != nil {
+		return nil, false, &rejection{err.Field, fmt.Sprintf("%v: %v", position, err.Message)}
+	}
+	return &product, true, nil
+}
+
+// findBulkDuplicates находит корректные предложения с уже встречавшимся offer id, как findDuplicates для листа
+func findBulkDuplicates(entries []*bulkEntry, sellerId int64, policy string) map[fmt.Stringer]*rejection {
+	occurrences := map[int64][]fmt.Stringer{}
+	for i, entry := range entries {
+		position := offerPosition(i)
+		product, _, rejected := parseOffer(entry, position, sellerId)
+		if rejected != nil {
+			continue
+		}
+		occurrences[product.OfferId] = append(occurrences[product.OfferId], position)
+	}
+	duplicates := map[fmt.Stringer]*rejection{}
+	addDuplicates(duplicates, occurrences, policy)
+	return duplicates
+}
+
+// applyOffers делит предложения на пачки по BatchSize и сохраняет их параллельно, как пачки листа.
+// Пачки с checkpoint уже закоммичены до перезапуска и только учитываются в прогрессе
+func (c *Controller) applyOffers(job *jobs.Job, entries []*bulkEntry, checkpoints map[jobs.BatchKey]*jobs.Checkpoint) error {
+	job.Progress.Start(time.Now())
+	progress := job.Progress.AddSheet("offers", len(entries))
+
+	job.SetStatus("searching for duplicate offers")
+	duplicates := findBulkDuplicates(entries, job.SellerId, c.Config.DuplicateOffers)
+
+	job.SetStatus("working with offers")
+	offersWg := &sync.WaitGroup{}
+	for from := 0; from < len(entries); from += job.BatchSize {
+		if c.ctx.Err() != nil {
+			break
+		}
+		batchEntries := entries[from:min(from+job.BatchSize, len(entries))]
+		key := jobs.BatchKey{Batch: from / job.BatchSize}
+		if checkpoint, ok := checkpoints[key]; ok {
+			c.restoreBatch(job, progress, len(batchEntries), checkpoint)
+			continue
+		}
+		offersWg.Add(1)
+		go c.workWithOffers(offersWg, batchEntries, from, key, job, progress, duplicates)
+	}
+	offersWg.Wait()
+	return nil
+}
+
+func (c *Controller) workWithOffers(offersWg *sync.WaitGroup, entries []*bulkEntry, from int, key jobs.BatchKey, job *jobs.Job, progress int, duplicates map[fmt.Stringer]*rejection) {
+	defer offersWg.Done()
+	if c.ctx.Err() != nil {
+		return
+	}
+	defer job.Progress.AddProcessed(progress, len(entries))
+	defer c.Metrics.RowsProcessed.Add(float64(len(entries)))
+	batch := &storage.Batch{SellerId: job.SellerId}
+	offerErrors := []string{}
+	for i, entry := range entries {
+		position := offerPosition(from + i)
+		product, available, rejected := parseOffer(entry, position, job.SellerId)
+		if rejected == nil {
+			rejected = duplicates[position]
+		}
+		if rejected != nil {
+			offerErrors = c.rowError(job, offerErrors, rejected.reason, rejected.message, "offer", string(entry.raw))
+			continue
+		}
+		if !available {
+			batch.Unavailable = append(batch.Unavailable, product.OfferId)
+			continue
+		}
+		product.SellerId = job.SellerId
+		if product.Currency == "" {
+			product.Currency = model.DefaultCurrency
+		}
+		batch.Upsert = append(batch.Upsert, product)
+	}
+
+	c.saveBatch(job, key, fmt.Sprintf("batch %v", key.Batch+1), batch, offerErrors)
+}
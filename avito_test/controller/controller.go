@@ -1,446 +1,697 @@
 package controller
 
 import (
+	"avito_test/config"
+	"avito_test/jobs"
+	"avito_test/logging"
+	"avito_test/metrics"
 	"avito_test/model"
-	"database/sql"
+	"avito_test/storage"
+	"avito_test/webhook"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/tealeg/xlsx"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type Controller struct {
-	DB                   *sql.DB
-	procNumber           int64
-	Goroutine2Status     map[int64]string
-	xlsxRequestWorkerMap map[int64]*xlsxRequestWorker
-	upsertedChan         chan *countData `json: "-"`
-	//updatedChan          chan *countData `json: "-"`
-	deletedChan      chan *countData `json: "-"`
-	errorStringsChan chan *errorData `json: "-"`
-	mutex            *sync.Mutex
-	waitChan         chan struct{}
+	Store    storage.Storage
+	Config   *config.Config
+	Jobs     *jobs.Registry
+	Notifier *webhook.Notifier
+	Metrics  *metrics.Metrics
+	Logger   *slog.Logger
+
+	//ctx отменяется, когда при остановке сервиса задачи не успели завершиться
+	ctx            context.Context
+	cancel         context.CancelFunc
+	running        sync.WaitGroup
+	activeJobs     int64
+	lifecycleMutex sync.Mutex
+	stopping       bool
 }
 
-type countData struct {
-	goroutineNum int64
-	count        int64
-}
-type errorData struct {
-	goroutineNum int64
-	errorStr     string
+func NewController(store storage.Storage, cfg *config.Config, logger *slog.Logger) *Controller {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Controller{
+		Store:    store,
+		Config:   cfg,
+		Jobs:     jobs.NewRegistry(),
+		Notifier: webhook.NewNotifier(cfg.WebhookSecret),
+		Logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	c.Jobs.FinishedTTL = cfg.FinishedJobsTTL
+	c.Metrics = metrics.New(func() float64 {
+		return float64(atomic.LoadInt64(&c.activeJobs))
+	})
+	return c
 }
 
-type xlsxRequestWorker struct {
-	//количество созданных товаров, обновлённых, удалённых и количество строк с ошибками
-	SenderId     int64
-	Created      int64
-	Updated      int64
-	Deleted      int64
-	ErrorStrings []string
-}
+func (c *Controller) GetProcStatus(w http.ResponseWriter, r *http.Request) {
+	sellerId, err := strconv.ParseInt(r.FormValue("seller"), 10, 64)
+	if err != nil {
+		logging.FromRequest(c.Logger, r).Warn("error in parsing seller id", "error", err)
+		writeText(w, 500, err.Error())
+		return
+	}
+	//чужие задачи неотличимы от несуществующих, чтобы нельзя было перебором проверить id
+	job, ok := c.Jobs.GetForSeller(r.FormValue("id"), sellerId)
+	if !ok {
+		//задачи нет в памяти, если она принята до перезапуска или давно завершилась, но её статус остаётся в бд
+		stored, err := c.Store.GetJob(r.Context(), r.FormValue("id"))
+		if errors.Is(err, storage.ErrUnknownJob) || (err == nil && stored.SellerId != sellerId) {
+			writeText(w, 500, "incorrect procedure number")
+			return
+		}
+		if err != nil {
+			logging.FromRequest(c.Logger, r).Error("error in loading job", "error", err)
+			writeText(w, 500, err.Error())
+			return
+		}
+		c.writeJSON(w, 200, &model.Job{Id: stored.Id, Status: stored.Status})
+		return
+	}
 
-func (c *Controller) inc() {
-	c.procNumber = c.procNumber + 1
+	c.writeJSON(w, 200, &model.Job{
+		Id:         job.Id,
+		Status:     job.Status(),
+		Progress:   job.Progress.Snapshot(time.Now()),
+		Deliveries: job.Deliveries(),
+	})
 }
 
-func NewController(db *sql.DB) *Controller {
-	return &Controller{
-		DB:                   db,
-		procNumber:           0,
-		Goroutine2Status:     make(map[int64]string),
-		xlsxRequestWorkerMap: make(map[int64]*xlsxRequestWorker),
-		upsertedChan:         make(chan *countData),
-		//updatedChan:          make(chan *countData),
-		deletedChan:      make(chan *countData),
-		errorStringsChan: make(chan *errorData),
-		mutex:            &sync.Mutex{},
-		waitChan:         make(chan struct{}),
+func (c *Controller) StreamProcEvents(w http.ResponseWriter, r *http.Request) {
+	sellerId, err := strconv.ParseInt(r.FormValue("seller"), 10, 64)
+	if err != nil {
+		logging.FromRequest(c.Logger, r).Warn("error in parsing seller id", "error", err)
+		writeText(w, 500, err.Error())
+		return
+	}
+	job, ok := c.Jobs.GetForSeller(r.PathValue("id"), sellerId)
+	if !ok {
+		writeText(w, 500, "incorrect procedure number")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeText(w, 500, "streaming is not supported")
+		return
+	}
+
+	//при переподключении браузер присылает номер последнего полученного события
+	from, err := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	if err != nil || from < 0 {
+		from = 0
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	for {
+		events, changed, closed := job.Events(from)
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				logging.FromRequest(c.Logger, r).Error("error during marshalling", "error", err)
+				return
+			}
+			fmt.Fprintf(w, "id: %v\nevent: %v\ndata: %s\n\n", event.Id, event.Type, data)
+			from = event.Id
+		}
+		flusher.Flush()
+		if closed {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
 	}
 }
 
-func (c *Controller) GetProcStatus(r *http.Request) (int, string) {
-	defer c.mutex.Unlock()
-	number, err := strconv.ParseInt(r.FormValue("number"), 10, 64)
+func (c *Controller) ListJobs(w http.ResponseWriter, r *http.Request) {
+	sellerId, err := strconv.ParseInt(r.FormValue("seller"), 10, 64)
 	if err != nil {
-		log.Println("error in atoi:", err)
-		return 500, err.Error()
+		logging.FromRequest(c.Logger, r).Warn("error in parsing seller id", "error", err)
+		writeText(w, 500, err.Error())
+		return
 	}
-	c.mutex.Lock()
-	if status, ok := c.Goroutine2Status[number]; ok {
-		return 200, status
-	} else {
-		return 500, "incorrect procedure number"
+
+	stored, err := c.Store.SellerJobs(r.Context(), sellerId)
+	if err != nil {
+		logging.FromRequest(c.Logger, r).Error("error in loading jobs", "error", err)
+		writeText(w, 500, err.Error())
+		return
+	}
+	registered := c.Jobs.ListBySeller(sellerId)
+	inMemory := map[string]bool{}
+	for _, job := range registered {
+		inMemory[job.Id] = true
 	}
-}
 
-func (c *Controller) FindOffersByParams(w http.ResponseWriter, r *http.Request) (int, string) {
-	sellerId := r.FormValue("seller")
-	offerId := r.FormValue("offer")
-	name := r.FormValue("name")
-	sqlQueryParams := []string{}
-	if sellerId != "" {
-		if _, err := strconv.ParseInt(sellerId, 10, 64); err != nil {
-			log.Println("error in parsing seller id:", err.Error())
-			return 500, err.Error()
-		} else {
-			sqlQueryParams = append(sqlQueryParams, fmt.Sprintf("seller_id = %v", sellerId))
-		}
+	//задачи из бд и из памяти упорядочиваются по времени создания: восстановленная после перезапуска задача
+	//может быть старше задач, которых в памяти уже нет. При равном времени задачи из бд идут первыми
+	type listedJob struct {
+		job       *model.Job
+		createdAt time.Time
 	}
-	if offerId != "" {
-		if _, err := strconv.ParseInt(offerId, 10, 64); err != nil {
-			log.Println("error in parsing offer id:", err.Error())
-			return 500, err.Error()
-		} else {
-			sqlQueryParams = append(sqlQueryParams, fmt.Sprintf("offer_id = %v", offerId))
+	listed := []listedJob{}
+	for _, job := range stored {
+		if !inMemory[job.Id] {
+			listed = append(listed, listedJob{&model.Job{Id: job.Id, Status: job.Status}, job.CreatedAt})
 		}
 	}
-	if name != "" {
-		sqlQueryParams = append(sqlQueryParams, fmt.Sprintf("name ilike '%v%v%v'", "%", name, "%"))
+	for _, job := range registered {
+		listed = append(listed, listedJob{&model.Job{
+			Id:       job.Id,
+			Status:   job.Status(),
+			Progress: job.Progress.Snapshot(time.Now()),
+		}, job.CreatedAt})
 	}
+	sort.SliceStable(listed, func(i, j int) bool {
+		return listed[i].createdAt.Before(listed[j].createdAt)
+	})
 
-	query := fmt.Sprintf("select seller_id, offer_id, name, price, quantity from product where %v",
-		strings.Join(sqlQueryParams, " and "))
+	sellerJobs := make([]*model.Job, len(listed))
+	for i, job := range listed {
+		sellerJobs[i] = job.job
+	}
+	c.writeJSON(w, 200, sellerJobs)
+}
 
-	rows, err := c.DB.Query(
-		query,
-	)
-	if err != nil && err != sql.ErrNoRows {
-		log.Println("error in select query:", err)
-		return 500, err.Error()
-	}
-
-	products := []*model.Product{}
-	for rows.Next() {
-		pr := &model.Product{}
-		err = rows.Scan(
-			&pr.SellerId,
-			&pr.OfferId,
-			&pr.Name,
-			&pr.Price,
-			&pr.Quantity,
-		)
+func (c *Controller) FindOffersByParams(w http.ResponseWriter, r *http.Request) {
+	filter := storage.ProductFilter{Name: r.FormValue("name")}
+	if sellerId := r.FormValue("seller"); sellerId != "" {
+		id, err := strconv.ParseInt(sellerId, 10, 64)
+		if err != nil {
+			logging.FromRequest(c.Logger, r).Warn("error in parsing seller id", "error", err)
+			writeText(w, 500, err.Error())
+			return
+		}
+		filter.SellerId = &id
+	}
+	if offerId := r.FormValue("offer"); offerId != "" {
+		id, err := strconv.ParseInt(offerId, 10, 64)
+		if err != nil {
+			logging.FromRequest(c.Logger, r).Warn("error in parsing offer id", "error", err)
+			writeText(w, 500, err.Error())
+			return
+		}
+		filter.OfferId = &id
+	}
+	if updatedSince := r.FormValue("updated_since"); updatedSince != "" {
+		since, err := time.Parse(time.RFC3339Nano, updatedSince)
 		if err != nil {
-			log.Println("error in scanning rows:", err.Error())
-			return 500, err.Error()
+			logging.FromRequest(c.Logger, r).Warn("error in parsing updated since", "error", err)
+			writeText(w, 500, err.Error())
+			return
 		}
-		products = append(products, pr)
+		filter.UpdatedSince = &since
+		//синхронизации нужны и снятые с продажи товары, чтобы убрать их у себя
+		filter.IncludeUnavailable = true
+	}
+	//без условий запрос отдал бы всю таблицу
+	if filter.SellerId == nil && filter.OfferId == nil && filter.Name == "" && filter.UpdatedSince == nil {
+		writeText(w, 500, "at least one of seller, offer, name or updated_since must be set")
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	return c.makeContentResponse(200, products)
+	start := time.Now()
+	products, err := c.Store.FindProducts(r.Context(), filter)
+	if err != nil {
+		logging.FromRequest(c.Logger, r).Error("error in select query", "error", err)
+		writeText(w, 500, err.Error())
+		return
+	}
+	c.Metrics.QueryDuration.Observe(time.Since(start).Seconds())
+
+	c.writeJSON(w, 200, products)
 }
 
-func (c *Controller) ReadFileFromRequest(r *http.Request) (int, string) {
+func (c *Controller) ReadFileFromRequest(w http.ResponseWriter, r *http.Request) {
+	//тело ограничивается до первого FormValue: он уже читает и разбирает всю форму
+	r.Body = http.MaxBytesReader(w, r.Body, c.Config.MaxUploadSize)
+	var tooLarge *http.MaxBytesError
+	if err := r.ParseMultipartForm(c.Config.MaxUploadSize); errors.As(err, &tooLarge) {
+		logging.FromRequest(c.Logger, r).Warn("upload is too large", "limit", tooLarge.Limit)
+		c.Metrics.Uploads.WithLabelValues("rejected").Inc()
+		writeText(w, 413, fmt.Sprintf("upload is larger than %v bytes", tooLarge.Limit))
+		return
+	}
+
 	senderId, err := strconv.ParseInt(r.FormValue("seller"), 10, 64)
 	if err != nil {
-		log.Println("error in parsing seller id:", err.Error())
-		return 500, err.Error()
+		logging.FromRequest(c.Logger, r).Warn("error in parsing seller id", "error", err)
+		c.Metrics.Uploads.WithLabelValues("rejected").Inc()
+		writeText(w, 500, err.Error())
+		return
 	}
 
-	c.Goroutine2Status[c.procNumber] = "new"
-	c.xlsxRequestWorkerMap[c.procNumber] = &xlsxRequestWorker{
-		SenderId: senderId,
+	sheets := parseSheetSelection(r.FormValue("sheets"))
+
+	callbackUrl := r.FormValue("callback_url")
+	if callbackUrl != "" {
+		if err := c.Notifier.ValidateURL(callbackUrl); err != nil {
+			logging.FromRequest(c.Logger, r).Warn("error in parsing callback url", "error", err)
+			c.Metrics.Uploads.WithLabelValues("rejected").Inc()
+			writeText(w, 500, err.Error())
+			return
+		}
 	}
 
-	log.Println("File Upload Endpoint Hit")
+	if !c.startJob() {
+		c.Metrics.Uploads.WithLabelValues("rejected").Inc()
+		writeText(w, 503, "service is shutting down")
+		return
+	}
+	job := c.Jobs.Create(senderId)
+	job.CallbackUrl = callbackUrl
+	job.Sheets = sheets
+	job.Logger = logging.FromRequest(c.Logger, r).With("job_id", job.Id, "seller_id", job.SellerId)
+	job.Logger.Info("file upload started")
 
-	//Max size of file set to 120MB
-	r.ParseMultipartForm(120 << 20)
 	file, handler, err := r.FormFile("file")
 	if err != nil {
-		log.Println("error retrieving the file:", err)
-		c.mutex.Lock()
-		c.Goroutine2Status[c.procNumber] = fmt.Sprintf("error: %v", err.Error())
-		c.mutex.Unlock()
-		return 500, err.Error()
+		job.Logger.Warn("error retrieving the file", "error", err)
+		job.Finish(fmt.Sprintf("error: %v", err.Error()))
+		c.finishRunning()
+		c.Metrics.Uploads.WithLabelValues("rejected").Inc()
+		writeText(w, 500, err.Error())
+		return
 	}
 
-	go c.workWithTempFile(file, handler, c.procNumber)
-	defer c.inc()
-	return 200, strconv.FormatInt(c.procNumber, 10)
-}
-
-func (c *Controller) ListenControllerChans() {
-	for {
-		select {
-		case cData := <-c.upsertedChan:
-			c.mutex.Lock()
-			worker := c.xlsxRequestWorkerMap[cData.goroutineNum]
-			worker.Created += cData.count
-			c.mutex.Unlock()
-			c.waitChan <- struct{}{}
-		case cData := <-c.deletedChan:
-			c.mutex.Lock()
-			worker := c.xlsxRequestWorkerMap[cData.goroutineNum]
-			worker.Deleted += cData.count
-			c.mutex.Unlock()
-			c.waitChan <- struct{}{}
-		case cData := <-c.errorStringsChan:
-			c.mutex.Lock()
-			worker := c.xlsxRequestWorkerMap[cData.goroutineNum]
-			worker.ErrorStrings = append(worker.ErrorStrings, cData.errorStr)
-			c.mutex.Unlock()
-			c.waitChan <- struct{}{}
-		}
-	}
+	c.Metrics.Uploads.WithLabelValues("accepted").Inc()
+	go c.workWithTempFile(file, handler, job)
+	writeText(w, 200, job.Id)
 }
 
-func (c *Controller) workWithTempFile(file multipart.File, handler *multipart.FileHeader, grnumber int64) {
+func (c *Controller) workWithTempFile(file multipart.File, handler *multipart.FileHeader, job *jobs.Job) {
+	defer c.finishRunning()
 	defer file.Close()
-	log.Printf("Uploaded File: %+v\n", handler.Filename)
-	log.Printf("File Size: %+v\n", handler.Size)
-	wg := &sync.WaitGroup{}
+	job.Logger.Info("file uploaded", "filename", handler.Filename, "size", handler.Size)
 	fileParams := strings.Split(handler.Filename, ".")
 	if fileParams[len(fileParams)-1] != "xlsx" {
 		err := fmt.Errorf("unsupported file type: %v", fileParams[len(fileParams)-1])
-		log.Println(err.Error())
-		c.mutex.Lock()
-		c.Goroutine2Status[grnumber] = fmt.Sprintf("error: %v", err.Error())
-		c.mutex.Unlock()
+		job.Logger.Warn("unsupported file type", "error", err)
+		c.finishJob(job, fmt.Sprintf("error: %v", err.Error()))
 		return
 	}
 
-	tempFile, err := ioutil.TempFile("temp_files", "upload-*.xlsx")
+	filePath, err := c.storeUpload(file, job.Id, ".xlsx")
 	if err != nil {
-		err := fmt.Errorf("error in creating temp file: %v", err)
-		log.Println(err.Error())
-		c.mutex.Lock()
-		c.Goroutine2Status[grnumber] = fmt.Sprintf("error: %v", err.Error())
-		c.mutex.Unlock()
+		job.Logger.Error("error in storing file", "error", err)
+		c.finishJob(job, fmt.Sprintf("error: %v", err.Error()))
 		return
 	}
-	defer tempFile.Close()
 
-	bytes, err := ioutil.ReadAll(file)
-	if err != nil {
-		err := fmt.Errorf("error in reading file: %v", err.Error())
-		log.Println(err.Error())
-		c.mutex.Lock()
-		c.Goroutine2Status[grnumber] = fmt.Sprintf("error: %v", err.Error())
-		c.mutex.Unlock()
+	storedJob := &storage.StoredJob{
+		Id:          job.Id,
+		SellerId:    job.SellerId,
+		FilePath:    filePath,
+		CallbackUrl: job.CallbackUrl,
+		BatchSize:   c.Config.BatchSize,
+		Sheets:      job.Sheets,
+		CreatedAt:   job.CreatedAt,
+	}
+	if err := c.Store.InsertJob(c.ctx, storedJob, "file prepared for using"); err != nil {
+		err := fmt.Errorf("error in saving job: %v", err)
+		job.Logger.Error("error in saving job", "error", err)
+		if err := os.Remove(filePath); err != nil {
+			job.Logger.Error("error in deleting file", "error", err)
+		}
+		c.finishJob(job, fmt.Sprintf("error: %v", err.Error()))
 		return
 	}
+	job.FilePath = filePath
+	job.BatchSize = storedJob.BatchSize
+	job.SetStatus("file prepared for using")
 
-	tempFile.Write(bytes)
-	c.Goroutine2Status[grnumber] = "file prepared for using"
+	c.importFile(job, map[jobs.BatchKey]*jobs.Checkpoint{})
+}
 
-	wg.Add(1)
-	go c.readAndParseXLSXFile(wg, tempFile.Name(), grnumber)
+// storeUpload пишет файл во временную директорию и после полной записи переносит его в хранилище,
+// так что в хранилище не бывает недописанных файлов
+func (c *Controller) storeUpload(file io.Reader, jobId string, extension string) (string, error) {
+	tempFile, err := ioutil.TempFile(c.Config.TempDir, "upload-*"+extension)
+	if err != nil {
+		return "", fmt.Errorf("error in creating temp file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
 
-	wg.Wait()
+	_, err = io.Copy(tempFile, file)
+	closeErr := tempFile.Close()
+	if err != nil {
+		return "", fmt.Errorf("error in reading file: %w", err)
+	}
+	if closeErr != nil {
+		return "", fmt.Errorf("error in writing temp file: %v", closeErr)
+	}
 
-	err = os.Remove(tempFile.Name())
+	filePath := filepath.Join(c.Config.StorageDir, jobId+extension)
+	if err := moveFile(tempFile.Name(), filePath); err != nil {
+		return "", fmt.Errorf("error in moving file to storage: %v", err)
+	}
+	return filePath, nil
+}
 
-	c.mutex.Lock()
-	worker := c.xlsxRequestWorkerMap[grnumber]
-	finishStr := fmt.Sprintf(
-		"finished with result: created or updated - %v,\ndeleted - %v,\nerrors - %v",
-		worker.Created,
-		worker.Deleted,
-		strings.Join(worker.ErrorStrings, ",\n"),
-	)
+// moveFile переносит файл в хранилище. Временная директория и хранилище могут быть на разных файловых системах
+// (в docker-compose хранилище - отдельный volume), тогда rename невозможен: файл копируется во временный файл
+// рядом с целевым и уже он переименовывается, так что недописанный файл под именем задачи не появится
+func moveFile(from string, to string) error {
+	err := os.Rename(from, to)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := ioutil.TempFile(filepath.Dir(to), filepath.Base(to)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(target.Name())
 
-	c.Goroutine2Status[grnumber] = finishStr
-	c.mutex.Unlock()
+	_, err = io.Copy(target, source)
+	if err == nil {
+		err = target.Sync()
+	}
+	closeErr := target.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if err := os.Rename(target.Name(), to); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
 
+// ResumeJobs перезапускает задачи, не завершённые до остановки или падения сервиса,
+// пачки с записанным checkpoint повторно не обрабатываются
+func (c *Controller) ResumeJobs() error {
+	storedJobs, err := c.Store.UnfinishedJobs(c.ctx)
 	if err != nil {
-		log.Println("error in deleting file:", err)
-		return
+		return err
 	}
+	for _, storedJob := range storedJobs {
+		checkpoints, err := c.Store.Checkpoints(c.ctx, storedJob.Id)
+		if err != nil {
+			return err
+		}
+		if !c.startJob() {
+			return nil
+		}
 
-	return
+		job := c.Jobs.Restore(storedJob.Id, storedJob.SellerId)
+		job.CallbackUrl = storedJob.CallbackUrl
+		job.FilePath = storedJob.FilePath
+		job.BatchSize = storedJob.BatchSize
+		job.Sheets = storedJob.Sheets
+		job.CreatedAt = storedJob.CreatedAt
+		job.Logger = c.Logger.With("job_id", job.Id, "seller_id", job.SellerId)
+		job.SetStatus(fmt.Sprintf("resumed after restart, %v batches already committed", len(checkpoints)))
+		job.Logger.Info("resuming job", "committed_batches", len(checkpoints))
+
+		go func() {
+			defer c.finishRunning()
+			c.importFile(job, checkpoints)
+		}()
+	}
+	return nil
 }
 
-func (c *Controller) readAndParseXLSXFile(fileWg *sync.WaitGroup, filename string, grnumber int64) {
-	defer fileWg.Done()
+// importFile обрабатывает сохранённый файл задачи: xlsx из /send или тело запроса POST /offers/bulk
+func (c *Controller) importFile(job *jobs.Job, checkpoints map[jobs.BatchKey]*jobs.Checkpoint) {
+	c.runImport(job, func() error {
+		if filepath.Ext(job.FilePath) == bulkExtension {
+			entries, err := readBulkFile(job.FilePath)
+			if err != nil {
+				return err
+			}
+			return c.applyOffers(job, entries, checkpoints)
+		}
+		return c.readAndParseXLSXFile(job, checkpoints)
+	})
+}
 
-	xlsxFile, err := xlsx.OpenFile(fmt.Sprintf("%v", filename))
-	if err != nil {
-		err := fmt.Errorf("error in opening xlsx file: %v", err)
-		log.Println(err)
-		c.mutex.Lock()
-		c.Goroutine2Status[grnumber] = fmt.Sprintf("error: %v", err.Error())
-		c.mutex.Unlock()
+// runImport выполняет обработку и завершает задачу итогом, ошибкой или прерыванием при остановке сервиса
+func (c *Controller) runImport(job *jobs.Job, process func() error) {
+	if err := process(); err != nil {
+		job.Logger.Error("error in reading file", "error", err)
+		c.finishJob(job, fmt.Sprintf("error: %v", err.Error()))
 		return
 	}
 
-	sheetWg := &sync.WaitGroup{}
-	//ctx, cancelFunc := context.WithCancel(context.Background())
-	for _, sheet := range xlsxFile.Sheets {
-		sheetWg.Add(1)
-		go c.parseSheet(sheetWg, sheet, grnumber)
+	if c.ctx.Err() != nil {
+		progress := job.Progress.Snapshot(time.Now())
+		status := fmt.Sprintf(
+			"interrupted: service is shutting down, processed %v of %v rows",
+			progress.ProcessedRows,
+			progress.TotalRows,
+		)
+		c.Metrics.Uploads.WithLabelValues("interrupted").Inc()
+		job.Logger.Warn("job interrupted", "processed_rows", progress.ProcessedRows, "total_rows", progress.TotalRows)
+		//задача остаётся незавершённой в бд и продолжится после перезапуска
+		//c.ctx уже отменён, статус сохраняется без него
+		if err := c.Store.SetJobStatus(context.Background(), job.Id, status); err != nil {
+			job.Logger.Error("error in saving job status", "error", err)
+		}
+		job.Finish(status)
+		return
 	}
 
-	c.mutex.Lock()
-	c.Goroutine2Status[grnumber] = "working with sheets"
-	c.mutex.Unlock()
+	//deleted в статусе - товары, снятые с продажи; формат статуса не меняется ради существующих клиентов
+	finishStr := fmt.Sprintf(
+		"finished with result: created or updated - %v,\ndeleted - %v,\nerrors - %v",
+		job.Progress.Created(),
+		job.Progress.Deleted(),
+		strings.Join(job.Progress.ErrorStrings(), ",\n"),
+	)
+
+	c.finishJob(job, finishStr)
+}
+
+// finishJob сохраняет итоговый статус, удаляет файл из хранилища, завершает задачу и отправляет callback
+func (c *Controller) finishJob(job *jobs.Job, status string) {
+	if strings.HasPrefix(status, "error") {
+		c.Metrics.Uploads.WithLabelValues("failed").Inc()
+	} else {
+		c.Metrics.Uploads.WithLabelValues("finished").Inc()
+	}
+	job.Logger.Info("job finished", "status", status)
+	if job.FilePath != "" {
+		if err := c.Store.FinishJob(context.Background(), job.Id, status); err != nil {
+			job.Logger.Error("error in saving job status", "error", err)
+		}
+		if err := os.Remove(job.FilePath); err != nil {
+			job.Logger.Error("error in deleting file", "error", err)
+		}
+	}
+	job.Finish(status)
+	c.sendCallback(job)
+}
 
-	sheetWg.Wait()
+func (c *Controller) sendCallback(job *jobs.Job) {
+	if job.CallbackUrl == "" {
+		return
+	}
+	payload := &model.JobCallback{
+		SellerId: job.SellerId,
+		Job: &model.Job{
+			Id:       job.Id,
+			Status:   job.Status(),
+			Progress: job.Progress.Snapshot(time.Now()),
+		},
+	}
+	if err := c.Notifier.Send(c.ctx, job.CallbackUrl, payload, job.AddDeliveryAttempt); err != nil {
+		job.Logger.Error("error in sending callback", "callback_url", job.CallbackUrl, "error", err)
+	}
 }
 
-func (c *Controller) parseSheet(sheetWg *sync.WaitGroup, sheet *xlsx.Sheet, grnumber int64) {
-	defer sheetWg.Done()
+func (c *Controller) readAndParseXLSXFile(job *jobs.Job, checkpoints map[jobs.BatchKey]*jobs.Checkpoint) error {
+	xlsxFile, err := xlsx.OpenFile(job.FilePath)
+	if err != nil {
+		return fmt.Errorf("error in opening xlsx file: %v", err)
+	}
+	sheets, err := selectSheets(xlsxFile.Sheets, job.Sheets)
+	if err != nil {
+		return err
+	}
+
+	//листы регистрируются заранее, чтобы общее число строк было известно с самого начала
+	job.Progress.Start(time.Now())
+	for _, sheet := range sheets {
+		sheet.progress = job.Progress.AddSheet(sheet.Name, len(sheet.Rows))
+	}
+
+	job.SetStatus("searching for duplicate offers")
+	duplicates := findDuplicates(sheets, c.Config.DuplicateOffers)
+
+	job.SetStatus("working with sheets")
 
-	rows := make([]*xlsx.Row, 100)
+	//листы обрабатываются по очереди, так что товар с более позднего листа перезаписывает товар с раннего
+	for _, sheet := range sheets {
+		c.parseSheet(sheet, job, checkpoints, duplicates)
+		if c.ctx.Err() != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *Controller) parseSheet(sheet *importSheet, job *jobs.Job, checkpoints map[jobs.BatchKey]*jobs.Checkpoint, duplicates map[fmt.Stringer]*rejection) {
+	batchSize := job.BatchSize
+	rows := make([]*xlsx.Row, batchSize)
 	rowsWg := &sync.WaitGroup{}
 	lastNumber := 0
 	for i, row := range sheet.Rows {
-		rows[i%100] = row
-		lastNumber = i % 100
-		if (i+1)%100 == 0 {
+		if c.ctx.Err() != nil {
+			rowsWg.Wait()
+			return
+		}
+		rows[i%batchSize] = row
+		lastNumber = i % batchSize
+		//последняя неполная пачка отправляется вместе с последней строкой листа
+		if (i+1)%batchSize == 0 || i == len(sheet.Rows)-1 {
+			key := jobs.BatchKey{Sheet: sheet.index, Batch: i / batchSize}
+			if checkpoint, ok := checkpoints[key]; ok {
+				c.restoreBatch(job, sheet.progress, lastNumber+1, checkpoint)
+				continue
+			}
 			rowsWg.Add(1)
-			goRows := make([]*xlsx.Row, 100)
+			goRows := make([]*xlsx.Row, batchSize)
 			copy(goRows, rows)
-			go c.workWithRows(rowsWg, goRows, lastNumber, grnumber)
-
+			go c.workWithRows(rowsWg, goRows, lastNumber, sheet, key, job, duplicates)
 		}
 	}
-	rowsWg.Add(1)
-	go c.workWithRows(rowsWg, rows, lastNumber, grnumber)
 
 	rowsWg.Wait()
 }
 
-func (c *Controller) workWithRows(rowsWs *sync.WaitGroup, rows []*xlsx.Row, lastNumber int, grnumber int64) {
+// restoreBatch учитывает в прогрессе пачку, закоммиченную до перезапуска
+func (c *Controller) restoreBatch(job *jobs.Job, progress int, rowsCount int, checkpoint *jobs.Checkpoint) {
+	job.Progress.AddCreated(checkpoint.Created)
+	job.Progress.AddDeleted(checkpoint.Deleted)
+	for _, errorStr := range checkpoint.ErrorStrings {
+		job.Progress.AddError(errorStr)
+	}
+	job.Progress.AddProcessed(progress, rowsCount)
+}
+
+func (c *Controller) workWithRows(rowsWs *sync.WaitGroup, rows []*xlsx.Row, lastNumber int, sheet *importSheet, key jobs.BatchKey, job *jobs.Job, duplicates map[fmt.Stringer]*rejection) {
 	defer rowsWs.Done()
-	deleteData := []string{}
-	upsertData := []string{}
+	if c.ctx.Err() != nil {
+		return
+	}
+	defer job.Progress.AddProcessed(sheet.progress, lastNumber+1)
+	defer c.Metrics.RowsProcessed.Add(float64(lastNumber + 1))
+	batch := &storage.Batch{SellerId: job.SellerId}
+	rowErrors := []string{}
 	for i := 0; i <= lastNumber; i++ {
-		offerId, err := strconv.Atoi(rows[i].Cells[0].Value)
-		if err != nil {
-			err := fmt.Sprintf("row %v: offer id is not a number, err: %v", rows[i].Cells, err)
-			log.Println(err)
-			c.errorStringsChan <- &errorData{
-				goroutineNum: grnumber,
-				errorStr:     err,
-			}
+		if blankRow(rows[i]) {
 			continue
 		}
-		if offerId <= 0 {
-			err := fmt.Sprintf("row %v: offer id lower or equals zero", rows[i].Cells)
-			log.Println(err)
-			c.errorStringsChan <- &errorData{
-				goroutineNum: grnumber,
-				errorStr:     err,
-			}
-			continue
+		position := rowPosition{sheet: key.Sheet, row: key.Batch*job.BatchSize + i}
+		product, available, rejected := parseRow(rows[i], position)
+		if rejected == nil {
+			rejected = duplicates[position]
 		}
-
-		available, err := strconv.ParseBool(strings.ToLower(rows[i].Cells[4].Value))
-		if err != nil {
-			err := fmt.Sprintf("row %v: error in parsing available: %v", rows[i].Cells, err)
-			log.Println(err)
-			c.errorStringsChan <- &errorData{
-				goroutineNum: grnumber,
-				errorStr:     err,
-			}
+		if rejected != nil {
+			rowErrors = c.rowError(job, rowErrors, rejected.reason, rejected.message, "cells", cellValues(rows[i]))
 			continue
 		}
 		if !available {
-			deleteData = append(deleteData, strconv.Itoa(offerId))
-			continue
-		}
-
-		name := rows[i].Cells[1].Value
-
-		price, err := strconv.Atoi(rows[i].Cells[2].Value)
-		if err != nil {
-			err := fmt.Sprintf("row %v: price is not a number, err: %v", rows[i].Cells, err)
-			log.Println(err)
-			c.errorStringsChan <- &errorData{
-				goroutineNum: grnumber,
-				errorStr:     err,
-			}
+			batch.Unavailable = append(batch.Unavailable, product.OfferId)
 			continue
 		}
-		if price < 0 {
-			err := fmt.Sprintf("row %v: price lower than zero", rows[i].Cells)
-			log.Println(err)
-			c.errorStringsChan <- &errorData{
-				goroutineNum: grnumber,
-				errorStr:     err,
-			}
-			continue
+		product.SellerId = job.SellerId
+		if product.Currency == "" {
+			product.Currency = model.DefaultCurrency
 		}
+		batch.Upsert = append(batch.Upsert, product)
+	}
 
-		quantity, err := strconv.Atoi(rows[i].Cells[3].Value)
-		if err != nil {
-			err := fmt.Sprintf("row %v: quantity is not a number, err: %v", rows[i].Cells, err)
-			log.Println(err)
-			c.errorStringsChan <- &errorData{
-				goroutineNum: grnumber,
-				errorStr:     err,
-			}
-			continue
-		}
-		if quantity < 0 {
-			err := fmt.Sprintf("row %v: quantity lower than zero", rows[i].Cells)
-			log.Println(err)
-			c.errorStringsChan <- &errorData{
-				goroutineNum: grnumber,
-				errorStr:     err,
-			}
-			continue
-		}
+	c.saveBatch(job, key, fmt.Sprintf("sheet %v, batch %v", key.Sheet+1, key.Batch+1), batch, rowErrors)
+}
 
-		upsertData = append(upsertData, fmt.Sprintf(
-			"(%v, %v, '%v', %v, %v, %v)",
-			strconv.FormatInt(c.xlsxRequestWorkerMap[grnumber].SenderId, 10),
-			strconv.Itoa(offerId),
-			name,
-			strconv.Itoa(price),
-			strconv.Itoa(quantity),
-			strconv.FormatBool(available),
-		))
-	}
-
-	if len(upsertData) != 0 {
-		result, err := c.DB.Exec(
-			fmt.Sprintf("insert into product (seller_id, offer_id, name, price, quantity, available) "+
-				"values %v on conflict on constraint product_id do update set name = excluded.name, "+
-				"price = excluded.price, quantity = excluded.quantity, available = excluded.available;",
-				strings.Join(upsertData, ", ")))
-		if err != nil {
-			log.Println("error in upsert data:", err)
+// saveBatch сохраняет пачку вместе с её checkpoint, place - пачка в тексте ошибки
+func (c *Controller) saveBatch(job *jobs.Job, key jobs.BatchKey, place string, batch *storage.Batch, rowErrors []string) {
+	checkpoint := &jobs.Checkpoint{ErrorStrings: rowErrors}
+	if err := c.Store.SaveBatch(c.ctx, job.Id, key, batch, checkpoint); err != nil {
+		if c.ctx.Err() != nil {
 			return
 		}
-		rowsUpserted, _ := result.RowsAffected()
-		c.upsertedChan <- &countData{
-			goroutineNum: grnumber,
-			count:        rowsUpserted,
-		}
-		<-c.waitChan
+		err := fmt.Sprintf("%v: error in saving data: %v", place, err)
+		job.Logger.Error("error in saving batch", "sheet", key.Sheet+1, "batch", key.Batch+1, "error", err)
+		job.Progress.AddError(err)
+		//отклонённые строки пачки уже посчитаны со своей причиной
+		c.Metrics.RowsFailed.WithLabelValues("database").Add(float64(len(batch.Upsert) + len(batch.Unavailable)))
+		return
+	}
+	if len(batch.Upsert) != 0 {
+		job.Progress.AddCreated(checkpoint.Created)
+	}
+	if len(batch.Unavailable) != 0 {
+		job.Progress.AddDeleted(checkpoint.Deleted)
 	}
+}
 
-	if len(deleteData) != 0 {
-		result, err := c.DB.Exec(fmt.Sprintf("delete from product where offer_id in (%v) and seller_id = %v",
-			strings.Join(deleteData, ", "), c.xlsxRequestWorkerMap[grnumber].SenderId))
-		if err != nil {
-			log.Println("error in delete data:", err)
-			return
-		}
-		rowsDeleted, _ := result.RowsAffected()
-		c.deletedChan <- &countData{
-			goroutineNum: grnumber,
-			count:        rowsDeleted,
-		}
-		<-c.waitChan
+// rowError сохраняет ошибку строки в прогрессе задачи, будущем checkpoint пачки и метриках, attrs - содержимое строки для лога
+func (c *Controller) rowError(job *jobs.Job, rowErrors []string, reason string, errorStr string, attrs ...any) []string {
+	job.Logger.Warn("row rejected", append([]any{"reason", reason, "error", errorStr}, attrs...)...)
+	job.Progress.AddError(errorStr)
+	c.Metrics.RowsFailed.WithLabelValues(reason).Inc()
+	return append(rowErrors, errorStr)
+}
+
+func cellValues(row *xlsx.Row) []string {
+	values := make([]string, len(row.Cells))
+	for i, cell := range row.Cells {
+		values[i] = cell.Value
 	}
+	return values
+}
+
+func writeText(w http.ResponseWriter, code int, text string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write([]byte(text))
 }
 
-func (c *Controller) makeContentResponse(code int, content interface{}) (int, string) {
+func (c *Controller) writeJSON(w http.ResponseWriter, code int, content interface{}) {
 	byteResponse, err := json.Marshal(content)
 	if err != nil {
-		log.Println("Error during marshalling", err.Error())
-		return 500, err.Error()
+		c.Logger.Error("error during marshalling", "error", err)
+		writeText(w, 500, err.Error())
+		return
 	}
-	return code, string(byteResponse)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(byteResponse)
 }
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"avito_test/logging"
+	"avito_test/model"
+	"avito_test/storage"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/tealeg/xlsx"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// форматы выгрузки каталога
+const (
+	exportXLSX = "xlsx"
+	exportCSV  = "csv"
+)
+
+// ExportOffers отдаёт товары продавца файлом в раскладке колонок импорта,
+// чтобы его можно было отредактировать и загрузить обратно
+func (c *Controller) ExportOffers(w http.ResponseWriter, r *http.Request) {
+	sellerId, err := strconv.ParseInt(r.FormValue("seller"), 10, 64)
+	if err != nil {
+		logging.FromRequest(c.Logger, r).Warn("error in parsing seller id", "error", err)
+		writeText(w, 500, err.Error())
+		return
+	}
+	format := r.FormValue("format")
+	if format == "" {
+		format = exportXLSX
+	}
+	if format != exportXLSX && format != exportCSV {
+		writeText(w, 500, fmt.Sprintf("unsupported export format: %v", format))
+		return
+	}
+
+	response := &exportResponse{
+		ResponseWriter: w,
+		contentType:    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		fileName:       fmt.Sprintf("offers-%v.%v", sellerId, format),
+	}
+	filter := storage.ProductFilter{SellerId: &sellerId}
+	if format == exportCSV {
+		response.contentType = "text/csv; charset=utf-8"
+		err = c.writeCSV(r.Context(), response, filter)
+	} else {
+		err = c.writeXLSX(r.Context(), response, filter)
+	}
+	if err != nil && !response.started {
+		logging.FromRequest(c.Logger, r).Error("error in select query", "error", err)
+		writeText(w, 500, err.Error())
+		return
+	}
+	//заголовки уже отправлены, поэтому ошибку остаётся только залогировать
+	if err != nil {
+		logging.FromRequest(c.Logger, r).Error("error in writing export", "error", err, "format", format)
+		return
+	}
+	//пустой каталог - пустой файл
+	response.start()
+}
+
+// exportResponse откладывает заголовки выгрузки до первых записанных байт,
+// чтобы ошибку запроса к бд до начала выгрузки ещё можно было отдать кодом 500
+type exportResponse struct {
+	http.ResponseWriter
+	contentType string
+	fileName    string
+	started     bool
+}
+
+func (e *exportResponse) start() {
+	if e.started {
+		return
+	}
+	e.started = true
+	e.Header().Set("Content-Type", e.contentType)
+	e.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, e.fileName))
+	e.WriteHeader(200)
+}
+
+func (e *exportResponse) Write(p []byte) (int, error) {
+	e.start()
+	return e.ResponseWriter.Write(p)
+}
+
+// exportRow раскладывает товар по колонкам импорта, нулевые габариты остаются пустыми, как в загружаемом файле
+func exportRow(product *model.Product) []string {
+	row := make([]string, columnsCount)
+	row[offerIdColumn] = strconv.FormatInt(product.OfferId, 10)
+	row[nameColumn] = product.Name
+	row[priceColumn] = product.Price.String()
+	row[quantityColumn] = strconv.Itoa(product.Quantity)
+	row[availableColumn] = "true"
+	row[currencyColumn] = product.Currency
+	row[descriptionColumn] = product.Description
+	row[categoryColumn] = strings.Join(product.Category, " > ")
+	row[brandColumn] = product.Brand
+	row[barcodeColumn] = product.Barcode
+	row[imagesColumn] = strings.Join(product.Images, " ")
+	for column, size := range map[int]int{
+		weightColumn: product.Weight,
+		lengthColumn: product.Length,
+		widthColumn:  product.Width,
+		heightColumn: product.Height,
+	} {
+		if size != 0 {
+			row[column] = strconv.Itoa(size)
+		}
+	}
+	return row
+}
+
+// writeCSV пишет товары построчно по мере чтения из бд, весь каталог в памяти не собирается
+func (c *Controller) writeCSV(ctx context.Context, w io.Writer, filter storage.ProductFilter) error {
+	writer := csv.NewWriter(w)
+	err := c.Store.EachProduct(ctx, filter, func(product *model.Product) error {
+		row := exportRow(product)
+		for i, value := range row {
+			row[i] = escapeFormula(value)
+		}
+		return writer.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// escapeFormula защищает от выполнения формул при открытии csv в Excel: значение, которое начинается
+// с =, +, -, @, табуляции или возврата каретки, он считает формулой, а с апострофом в начале - текстом.
+// Поэтому csv - выгрузка только для просмотра и других систем: импорт принимает xlsx, и Excel, сохраняя csv
+// в xlsx, сам убирает апостроф, а записанный в ячейку текстом апостроф останется частью значения
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// writeXLSX пишет числа числовыми ячейками, а штрихкод - текстом, чтобы Excel не съел ведущие нули.
+// xlsx - zip-архив, который библиотека собирает целиком, поэтому товары загружаются в память
+func (c *Controller) writeXLSX(ctx context.Context, w io.Writer, filter storage.ProductFilter) error {
+	products, err := c.Store.FindProducts(ctx, filter)
+	if err != nil {
+		return err
+	}
+	file := xlsx.NewFile()
+	sheet, err := file.AddSheet("offers")
+	if err != nil {
+		return err
+	}
+	for _, product := range products {
+		row := sheet.AddRow()
+		for column, value := range exportRow(product) {
+			cell := row.AddCell()
+			switch {
+			case value == "":
+			case column == priceColumn:
+				cell.SetFloatWithFormat(float64(product.Price)/100, "0.00")
+			case column == offerIdColumn || column == quantityColumn || column >= weightColumn:
+				number, _ := strconv.ParseInt(value, 10, 64)
+				cell.SetInt64(number)
+			default:
+				cell.SetString(value)
+			}
+		}
+	}
+	return file.Write(w)
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+type readiness struct {
+	Database   string
+	Schema     string
+	ActiveJobs string
+}
+
+func (c *Controller) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeText(w, 200, "ok")
+}
+
+// Readyz проверяет доступность бд, наличие схемы и то, что очередь задач не переполнена
+func (c *Controller) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	code := 200
+	result := &readiness{
+		Database: "ok",
+		Schema:   "ok",
+	}
+
+	if err := c.Store.Ping(ctx); err != nil {
+		code = 503
+		result.Database = err.Error()
+		result.Schema = "unknown"
+	} else if err := c.Store.CheckSchema(ctx); err != nil {
+		code = 503
+		result.Schema = err.Error()
+	}
+
+	activeJobs := atomic.LoadInt64(&c.activeJobs)
+	result.ActiveJobs = fmt.Sprintf("%v/%v", activeJobs, c.Config.MaxActiveJobs)
+	if activeJobs >= int64(c.Config.MaxActiveJobs) {
+		code = 503
+	}
+	if c.isStopping() {
+		code = 503
+		result.ActiveJobs = "service is shutting down, " + result.ActiveJobs
+	}
+
+	c.writeJSON(w, code, result)
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"avito_test/model"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// currencySymbols - обозначения валют, которые встречаются в ценах прайс-листов,
+// более длинные обозначения стоят раньше своих начал
+var currencySymbols = []struct {
+	symbol string
+	code   string
+}{
+	{"руб.", "RUB"},
+	{"руб", "RUB"},
+	{"р.", "RUB"},
+	{"₽", "RUB"},
+	{"$", "USD"},
+	{"€", "EUR"},
+	{"£", "GBP"},
+	{"₸", "KZT"},
+	{"₴", "UAH"},
+}
+
+// parsePrice разбирает цену, записанную текстом в формате любой локали: "1 299,00 ₽", "$1,299.90",
+// "1.299,9 EUR", "199.9". Возвращает цену и валюту, указанную в самой цене, или пустую строку
+func parsePrice(value string) (model.Money, string, error) {
+	text, currency := cutCurrency(strings.TrimSpace(value))
+	//пробелы, в том числе неразрывные, и апострофы разделяют разряды
+	text = strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "", "'", "").Replace(text)
+
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	whole, fraction := text, ""
+	separator, ambiguous := decimalSeparator(text)
+	if ambiguous {
+		return 0, "", fmt.Errorf("parsing %q: ambiguous price, separator may be decimal or thousands one", value)
+	}
+	if separator != "" {
+		index := strings.LastIndex(text, separator)
+		whole, fraction = text[:index], text[index+1:]
+		if fraction == "" {
+			return 0, "", fmt.Errorf("parsing %q: invalid price", value)
+		}
+	}
+	whole, ok := removeGrouping(whole)
+	if !ok {
+		return 0, "", fmt.Errorf("parsing %q: invalid price", value)
+	}
+
+	canonical := sign + whole
+	if fraction != "" {
+		canonical += "." + fraction
+	}
+	price, err := model.ParseMoney(canonical)
+	if err != nil {
+		return 0, "", fmt.Errorf("parsing %q: invalid price", value)
+	}
+	return price, currency, nil
+}
+
+// parseNumericPrice разбирает значение числовой ячейки. Excel хранит его как float64,
+// поэтому 199.9 может прийти как "199.90000000000001" и округляется до копеек
+func parseNumericPrice(value string) (model.Money, error) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, fmt.Errorf("parsing %q: invalid price", value)
+	}
+	cents := math.Round(number * 100)
+	if math.Abs(number*100-cents) > 1e-6 || math.Abs(cents) > float64(model.MaxMoney) {
+		return 0, fmt.Errorf("parsing %q: price must have at most 2 decimal places", value)
+	}
+	return model.Money(cents), nil
+}
+
+// cutCurrency отрезает обозначение валюты или её код ISO 4217 в начале или в конце цены
+func cutCurrency(text string) (string, string) {
+	for _, currency := range currencySymbols {
+		symbol := currency.symbol
+		if len(text) >= len(symbol) && strings.EqualFold(text[len(text)-len(symbol):], symbol) {
+			return strings.TrimSpace(text[:len(text)-len(symbol)]), currency.code
+		}
+		if len(text) >= len(symbol) && strings.EqualFold(text[:len(symbol)], symbol) {
+			return strings.TrimSpace(text[len(symbol):]), currency.code
+		}
+	}
+	if len(text) > 3 && model.IsCurrencyCode(strings.ToUpper(text[len(text)-3:])) {
+		return strings.TrimSpace(text[:len(text)-3]), strings.ToUpper(text[len(text)-3:])
+	}
+	if len(text) > 3 && model.IsCurrencyCode(strings.ToUpper(text[:3])) {
+		return strings.TrimSpace(text[3:]), strings.ToUpper(text[:3])
+	}
+	return text, ""
+}
+
+// decimalSeparator определяет, какой из знаков "." и "," отделяет дробную часть. Если встречаются оба,
+// дробная часть идёт после последнего, а повторяющийся знак разделяет разряды. Единственный знак с тремя цифрами
+// после него неоднозначен, когда перед ним от одной до трёх цифр: "19.990" в одной локали - 19,99, а в другой - 19990,
+// поэтому такая цена не угадывается, а отклоняется
+func decimalSeparator(text string) (string, bool) {
+	comma, dot := strings.LastIndex(text, ","), strings.LastIndex(text, ".")
+	switch {
+	case comma >= 0 && dot >= 0:
+		if comma > dot {
+			return ",", false
+		}
+		return ".", false
+	case comma >= 0:
+		return singleSeparator(text, ",", comma)
+	case dot >= 0:
+		return singleSeparator(text, ".", dot)
+	}
+	return "", false
+}
+
+// singleSeparator разбирает цену с одним видом разделителя, index - его последнее вхождение
+func singleSeparator(text string, separator string, index int) (string, bool) {
+	if strings.Count(text, separator) > 1 {
+		return "", false
+	}
+	if len(text)-index-1 != 3 {
+		return separator, false
+	}
+	//с ведущим нулём или больше чем тремя цифрами целая часть не может быть группой разрядов
+	whole := text[:index]
+	if len(whole) > 3 || strings.HasPrefix(whole, "0") {
+		return separator, false
+	}
+	return "", true
+}
+
+// removeGrouping убирает разделители разрядов из целой части, проверяя, что они разбивают её на группы по три цифры
+func removeGrouping(whole string) (string, bool) {
+	groups := strings.FieldsFunc(whole, func(r rune) bool {
+		return r == ',' || r == '.'
+	})
+	if !strings.ContainsAny(whole, ".,") {
+		return whole, true
+	}
+	//разделитель разрядов один на всё число, пустых групп нет
+	if strings.Contains(whole, ",") && strings.Contains(whole, ".") || len(groups) != strings.Count(whole, ",")+strings.Count(whole, ".")+1 {
+		return "", false
+	}
+	if len(groups[0]) > 3 || strings.HasPrefix(groups[0], "0") {
+		return "", false
+	}
+	for _, group := range groups[1:] {
+		if len(group) != 3 {
+			return "", false
+		}
+	}
+	return strings.Join(groups, ""), true
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"avito_test/model"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	for value, expected := range map[string]struct {
+		price    model.Money
+		currency string
+	}{
+		"199":          {19900, ""},
+		"199.9":        {19990, ""},
+		"199,90":       {19990, ""},
+		"1 299,00 ₽":   {129900, "RUB"},
+		"1 299 руб.":   {129900, "RUB"},
+		"1299 р.":      {129900, "RUB"},
+		"$1,299.90":    {129990, "USD"},
+		"1.299,9 EUR":  {129990, "EUR"},
+		"1,299,000":    {129900000, ""},
+		"1.299.000,5":  {129900050, ""},
+		"1'299.50 chf": {129950, "CHF"},
+		"1,234,567.89": {123456789, ""},
+		"-5":           {-500, ""},
+		"0,5":          {50, ""},
+	} {
+		price, currency, err := parsePrice(value)
+		if err != nil || price != expected.price || currency != expected.currency {
+			t.Errorf("%q: got %v %q, %v want %v %q", value, price, currency, err, expected.price, expected.currency)
+		}
+	}
+
+	for _, value := range []string{"", "12x", "1,2,3", "1.299,99.5", "199,", "0,125,000", "1,29,900", "100.5555", "₽", "руб", "1,299", "19.990", "1.500 ₽", "-1,500"} {
+		if price, _, err := parsePrice(value); err == nil {
+			t.Errorf("%q: got price %v want error", value, price)
+		}
+	}
+}
+
+func TestParseNumericPrice(t *testing.T) {
+	for value, expected := range map[string]model.Money{
+		"199.9":              19990,
+		"199.90000000000001": 19990,
+		"1E3":                100000,
+		"0":                  0,
+	} {
+		if price, err := parseNumericPrice(value); err != nil || price != expected {
+			t.Errorf("%q: got %v, %v want %v", value, price, err, expected)
+		}
+	}
+	for _, value := range []string{"", "199.999", "NaN", "1e20"} {
+		if price, err := parseNumericPrice(value); err == nil {
+			t.Errorf("%q: got price %v want error", value, price)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package controller
+
+import (
+	"avito_test/config"
+	"avito_test/model"
+	"fmt"
+	"github.com/tealeg/xlsx"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// rowPosition - номер листа в файле и номер строки на листе, оба с нуля
+type rowPosition struct {
+	sheet int
+	row   int
+}
+
+func (p rowPosition) String() string {
+	return fmt.Sprintf("sheet %v, row %v", p.sheet+1, p.row+1)
+}
+
+// rejection - причина отказа строки для метрик и текст ошибки для отчёта
+type rejection struct {
+	reason  string
+	message string
+}
+
+// колонки строки файла
+const (
+	offerIdColumn = iota
+	nameColumn
+	priceColumn
+	quantityColumn
+	availableColumn
+	//дальше необязательные колонки
+	currencyColumn
+	descriptionColumn
+	categoryColumn
+	brandColumn
+	barcodeColumn
+	imagesColumn
+	weightColumn
+	lengthColumn
+	widthColumn
+	heightColumn
+	columnsCount
+)
+
+// cellValue возвращает значение ячейки без пробелов по краям, для отсутствующей ячейки - пустую строку.
+// У ячеек с формулой это значение, сохранённое при последнем пересчёте файла
+func cellValue(row *xlsx.Row, column int) string {
+	if column >= len(row.Cells) {
+		return ""
+	}
+	return strings.TrimSpace(row.Cells[column].Value)
+}
+
+// blankRow сообщает, что в колонках импорта нет значений, такие строки (например, отформатированные
+// пустые строки в конце листа) пропускаются без ошибки
+func blankRow(row *xlsx.Row) bool {
+	for column := 0; column < columnsCount; column++ {
+		if cellValue(row, column) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseInt разбирает целое число, в том числе записанное дробным с нулевой дробной частью, как "100.0".
+// Экспоненциальная и шестнадцатеричная запись и Inf не принимаются
+func parseInt(value string) (int, error) {
+	number, err := strconv.Atoi(value)
+	if err == nil {
+		return number, nil
+	}
+	whole, fraction, found := strings.Cut(value, ".")
+	if !found || fraction == "" || strings.Trim(fraction, "0") != "" {
+		return 0, err
+	}
+	number, wholeErr := strconv.Atoi(whole)
+	if wholeErr != nil {
+		return 0, err
+	}
+	return number, nil
+}
+
+// parseRow разбирает строку файла. Для недоступного товара заполнен только OfferId и available = false
+func parseRow(row *xlsx.Row, position rowPosition) (*model.Product, bool, *rejection) {
+	for column := 0; column < columnsCount && column < len(row.Cells); column++ {
+		if row.Cells[column].Formula() != "" && cellValue(row, column) == "" {
+			return nil, false, &rejection{"formula", fmt.Sprintf(
+				"%v: formula in column %v has no calculated value, recalculate and save the file", position, column+1,
+			)}
+		}
+	}
+
+	offerId, err := parseInt(cellValue(row, offerIdColumn))
+	if err != nil {
+		return nil, false, &rejection{"offer_id", fmt.Sprintf("%v: offer id is not a number, err: %v", position, err)}
+	}
+	if err := model.ValidateOfferId(int64(offerId)); err != nil {
+		return nil, false, &rejection{err.Field, fmt.Sprintf("%v: %v", position, err.Message)}
+	}
+
+	available, err := strconv.ParseBool(strings.ToLower(cellValue(row, availableColumn)))
+	if err != nil {
+		return nil, false, &rejection{"available", fmt.Sprintf("%v: error in parsing available: %v", position, err)}
+	}
+	if !available {
+		return &model.Product{OfferId: int64(offerId)}, false, nil
+	}
+
+	name := cellValue(row, nameColumn)
+
+	price, currency, err := cellPrice(row)
+	if err != nil {
+		return nil, false, &rejection{"price", fmt.Sprintf("%v: price is not a number, err: %v", position, err)}
+	}
+
+	if column := cellValue(row, currencyColumn); column != "" {
+		if !model.IsCurrencyCode(strings.ToUpper(column)) {
+			return nil, false, &rejection{"currency", fmt.Sprintf("%v: currency %q is not an ISO 4217 code", position, column)}
+		}
+		if currency != "" && currency != strings.ToUpper(column) {
+			return nil, false, &rejection{"currency", fmt.Sprintf(
+				"%v: price currency %v differs from currency column %v", position, currency, strings.ToUpper(column),
+			)}
+		}
+		currency = strings.ToUpper(column)
+	}
+
+	quantity, err := parseInt(cellValue(row, quantityColumn))
+	if err != nil {
+		return nil, false, &rejection{"quantity", fmt.Sprintf("%v: quantity is not a number, err: %v", position, err)}
+	}
+
+	product := &model.Product{
+		OfferId:     int64(offerId),
+		Name:        name,
+		Price:       price,
+		Currency:    currency,
+		Quantity:    quantity,
+		Description: cellValue(row, descriptionColumn),
+		Category:    splitList(cellValue(row, categoryColumn), model.CategorySeparators),
+		Brand:       cellValue(row, brandColumn),
+		Barcode:     cellBarcode(row),
+		Images:      splitList(cellValue(row, imagesColumn), model.ImageSeparators),
+	}
+	for _, size := range []struct {
+		name   string
+		column int
+		field  *int
+	}{
+		{"weight", weightColumn, &product.Weight},
+		{"length", lengthColumn, &product.Length},
+		{"width", widthColumn, &product.Width},
+		{"height", heightColumn, &product.Height},
+	} {
+		value := cellValue(row, size.column)
+		if value == "" {
+			continue
+		}
+		number, err := parseInt(value)
+		if err != nil {
+			return nil, false, &rejection{size.name, fmt.Sprintf("%v: %v is not a number, err: %v", position, size.name, err)}
+		}
+		*size.field = number
+	}
+
+	if err := product.Validate(); err != nil {
+		return nil, false, &rejection{err.Field, fmt.Sprintf("%v: %v", position, err.Message)}
+	}
+	return product, true, nil
+}
+
+// splitList делит значение ячейки по любому из разделителей, пустые части отбрасываются
+func splitList(value string, separators string) []string {
+	var list []string
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(separators, r)
+	}) {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	return list
+}
+
+// cellBarcode возвращает штрихкод. Excel хранит штрихкод в числовой ячейке как число и может записать его
+// в экспоненциальной форме, например 4.6012345678905E+12
+func cellBarcode(row *xlsx.Row) string {
+	value := cellValue(row, barcodeColumn)
+	if barcodeColumn < len(row.Cells) && row.Cells[barcodeColumn].Type() == xlsx.CellTypeNumeric {
+		if number, err := strconv.ParseFloat(value, 64); err == nil && number == math.Trunc(number) && number > 0 && number < 1e15 {
+			return strconv.FormatFloat(number, 'f', 0, 64)
+		}
+	}
+	return value
+}
+
+// cellPrice разбирает цену: числовую ячейку как число, текстовую - в формате любой локали с валютой
+func cellPrice(row *xlsx.Row) (model.Money, string, error) {
+	value := cellValue(row, priceColumn)
+	if priceColumn < len(row.Cells) && row.Cells[priceColumn].Type() == xlsx.CellTypeNumeric {
+		price, err := parseNumericPrice(value)
+		return price, "", err
+	}
+	return parsePrice(value)
+}
+
+// findDuplicates до обработки пачек просматривает весь файл и находит корректные строки с уже встречавшимся offer id.
+// Возвращает строки, которые по политике policy не применяются, с текстом ошибки, где указаны обе позиции.
+// Пачки листа обрабатываются параллельно, поэтому без этого победитель среди повторов был бы случайным.
+// Позиции собираются в порядке обработки листов, так что при политике last побеждает более поздний лист,
+// как если бы строки применялись по очереди, а проигравшие строки попадают в ошибки
+func findDuplicates(sheets []*importSheet, policy string) map[fmt.Stringer]*rejection {
+	occurrences := map[int64][]fmt.Stringer{}
+	for _, sheet := range sheets {
+		for i, row := range sheet.Rows {
+			position := rowPosition{sheet: sheet.index, row: i}
+			product, _, rejected := parseRow(row, position)
+			if rejected != nil {
+				continue
+			}
+			occurrences[product.OfferId] = append(occurrences[product.OfferId], position)
+		}
+	}
+	duplicates := map[fmt.Stringer]*rejection{}
+	addDuplicates(duplicates, occurrences, policy)
+	return duplicates
+}
+
+// addDuplicates применяет политику к позициям каждого offer id: строкам файла или предложениям POST /offers/bulk
+func addDuplicates(duplicates map[fmt.Stringer]*rejection, occurrences map[int64][]fmt.Stringer, policy string) {
+	for offerId, positions := range occurrences {
+		if len(positions) < 2 {
+			continue
+		}
+		switch policy {
+		case config.DuplicateFirst:
+			for _, position := range positions[1:] {
+				duplicates[position] = &rejection{"duplicate", fmt.Sprintf(
+					"%v: offer id %v is duplicated, first occurrence at %v is used", position, offerId, positions[0],
+				)}
+			}
+		case config.DuplicateLast:
+			last := positions[len(positions)-1]
+			for _, position := range positions[:len(positions)-1] {
+				duplicates[position] = &rejection{"duplicate", fmt.Sprintf(
+					"%v: offer id %v is duplicated, last occurrence at %v is used", position, offerId, last,
+				)}
+			}
+		default:
+			for i, position := range positions {
+				others := []string{}
+				for j, other := range positions {
+					if i != j {
+						others = append(others, other.String())
+					}
+				}
+				duplicates[position] = &rejection{"duplicate", fmt.Sprintf(
+					"%v: offer id %v is duplicated at %v, all occurrences are rejected", position, offerId, strings.Join(others, " and "),
+				)}
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"avito_test/model"
+	"github.com/tealeg/xlsx"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newRow(t testing.TB, values ...string) *xlsx.Row {
+	sheet, err := xlsx.NewFile().AddSheet("prices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := sheet.AddRow()
+	for _, value := range values {
+		row.AddCell().Value = value
+	}
+	return row
+}
+
+func TestParseRow(t *testing.T) {
+	position := rowPosition{sheet: 0, row: 4}
+	for _, test := range []struct {
+		values    []string
+		product   *model.Product
+		available bool
+		reason    string
+	}{
+		{
+			values:    []string{"1", "apple", "100", "5", "true"},
+			product:   &model.Product{OfferId: 1, Name: "apple", Price: 10000, Quantity: 5},
+			available: true,
+		},
+		{
+			values:    []string{" 2.0 ", " pear ", "100.0", "5.00", "TRUE"},
+			product:   &model.Product{OfferId: 2, Name: "pear", Price: 10000, Quantity: 5},
+			available: true,
+		},
+		{values: []string{"3", "", "", "", "false"}, product: &model.Product{OfferId: 3}},
+		{values: []string{"4", "short row"}, reason: "available"},
+		{values: []string{"5"}, reason: "available"},
+		{values: []string{}, reason: "offer_id"},
+		{
+			values:    []string{"6", "fraction", "1 299,90 ₽", "1", "true"},
+			product:   &model.Product{OfferId: 6, Name: "fraction", Price: 129990, Currency: "RUB", Quantity: 1},
+			available: true,
+		},
+		{
+			values:    []string{"7", "column", "$5", "1", "true", "usd"},
+			product:   &model.Product{OfferId: 7, Name: "column", Price: 500, Currency: "USD", Quantity: 1},
+			available: true,
+		},
+		{
+			values: []string{
+				"12", "pear", "30", "3", "true", "", "Сочная груша", "Фрукты > Груши", "Сад", "4006381333931",
+				"https://img.example.com/1.jpg, https://img.example.com/2.jpg", "180", "60.0", "60", "90",
+			},
+			product: &model.Product{
+				OfferId: 12, Name: "pear", Price: 3000, Quantity: 3, Description: "Сочная груша", Category: []string{"Фрукты", "Груши"},
+				Brand: "Сад", Barcode: "4006381333931", Images: []string{"https://img.example.com/1.jpg", "https://img.example.com/2.jpg"},
+				Weight: 180, Length: 60, Width: 60, Height: 90,
+			},
+			available: true,
+		},
+		{values: []string{"13", "bad barcode", "30", "3", "true", "", "", "", "", "4006381333932"}, reason: "barcode"},
+		{values: []string{"14", "bad image", "30", "3", "true", "", "", "", "", "", "img/1.jpg"}, reason: "images"},
+		{values: []string{"15", "bad weight", "30", "3", "true", "", "", "", "", "", "", "heavy"}, reason: "weight"},
+		{values: []string{"8", "conflict", "5 €", "1", "true", "USD"}, reason: "currency"},
+		{values: []string{"9", "bad currency", "5", "1", "true", "рубли"}, reason: "currency"},
+		{values: []string{"10", "precise", "100.5555", "1", "true"}, reason: "price"},
+		{values: []string{"11", "huge", "99999999999", "1", "true"}, reason: "price"},
+		{values: []string{"1e300", "huge", "1", "1", "true"}, reason: "offer_id"},
+		{values: []string{"1e3", "exponent", "1", "1", "true"}, reason: "offer_id"},
+		{values: []string{"0x1p3", "hex", "1", "1", "true"}, reason: "offer_id"},
+		{values: []string{"16", "exponent", "1", "1E+2", "true"}, reason: "quantity"},
+		{values: []string{"17", "infinity", "1", "Inf", "true"}, reason: "quantity"},
+		{values: []string{"18", "fraction", "1", "5.5", "true"}, reason: "quantity"},
+		{values: []string{"19", "no fraction", "1", "5.", "true"}, reason: "quantity"},
+		{values: []string{"20", "exponent", "30", "3", "true", "", "", "", "", "", "", "1e3"}, reason: "weight"},
+	} {
+		product, available, rejected := parseRow(newRow(t, test.values...), position)
+		if test.reason != "" {
+			if rejected == nil || rejected.reason != test.reason {
+				t.Errorf("%q: got rejection %+v want reason %v", test.values, rejected, test.reason)
+			}
+			continue
+		}
+		if rejected != nil || available != test.available || !reflect.DeepEqual(product, test.product) {
+			t.Errorf("%q: got %+v, %v, %+v want %+v, %v", test.values, product, available, rejected, test.product, test.available)
+		}
+	}
+}
+
+func TestParseRowFormulas(t *testing.T) {
+	row := newRow(t, "1", "apple", "100", "5", "true")
+	row.Cells[2].SetFormula("B7*2")
+	if _, _, rejected := parseRow(row, rowPosition{}); rejected != nil {
+		t.Errorf("formula with calculated value is rejected: %+v", rejected)
+	}
+
+	row.Cells[2].Value = ""
+	_, _, rejected := parseRow(row, rowPosition{})
+	if rejected == nil || rejected.reason != "formula" || !strings.Contains(rejected.message, "column 3") {
+		t.Errorf("got rejection %+v want formula in column 3", rejected)
+	}
+}
+
+// FuzzParseRow проверяет, что строка любой длины с любыми значениями не роняет разбор,
+// а принятая строка содержит корректный товар. Значения ячеек разделяются табуляцией
+func FuzzParseRow(f *testing.F) {
+	for _, seed := range []string{
+		"1\tapple\t100\t5\ttrue",
+		"1\tapple\t100.0\t5\tfalse",
+		"",
+		"1",
+		"\t\t\t\t\t\t",
+		"-1\t\t-5\t1e5\t1",
+		"9223372036854775807\tx\t1e308\tNaN\tt",
+		"1e3\tx\t1\t1E+2\ttrue",
+		"0x1p3\tx\t1\tInf\ttrue",
+		"-0.000\tx\t1\t+Inf\ttrue",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		values := []string{}
+		if line != "" {
+			values = strings.Split(line, "\t")
+		}
+		row := newRow(t, values...)
+
+		//пустота строки тоже проверяется на строках любой длины
+		blankRow(row)
+		product, available, rejected := parseRow(row, rowPosition{})
+		if rejected != nil {
+			if product != nil || rejected.reason == "" || !strings.HasPrefix(rejected.message, "sheet 1, row 1: ") {
+				t.Fatalf("incorrect rejection %+v with product %+v", rejected, product)
+			}
+			return
+		}
+		if product == nil || product.OfferId <= 0 {
+			t.Fatalf("accepted incorrect product %+v", product)
+		}
+		//целые принимаются только в десятичной записи, без экспоненты
+		if strings.Trim(strings.TrimSpace(values[0]), "0123456789.+-") != "" {
+			t.Fatalf("accepted offer id %q", values[0])
+		}
+		if available && (product.Price < 0 || product.Quantity < 0) {
+			t.Fatalf("accepted incorrect product %+v", product)
+		}
+	})
+}
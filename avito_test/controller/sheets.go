@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/tealeg/xlsx"
+	"strconv"
+	"strings"
+)
+
+// importSheet - лист файла, выбранный для импорта
+type importSheet struct {
+	*xlsx.Sheet
+	//номер листа в файле с нуля, по нему строятся позиции строк в ошибках и ключи пачек
+	index int
+	//номер листа в прогрессе задачи
+	progress int
+}
+
+// parseSheetSelection разбирает поле sheets формы загрузки: названия или номера листов с единицы через запятую
+func parseSheetSelection(value string) []string {
+	selection := []string{}
+	for _, sheet := range strings.Split(value, ",") {
+		if sheet = strings.TrimSpace(sheet); sheet != "" {
+			selection = append(selection, sheet)
+		}
+	}
+	return selection
+}
+
+// selectSheets возвращает листы в порядке обработки. Без выбора это все видимые листы в порядке файла,
+// с выбором - перечисленные листы в порядке перечисления, включая скрытые. Название листа важнее номера,
+// так что лист с названием "2" выбирается по названию
+func selectSheets(sheets []*xlsx.Sheet, selection []string) ([]*importSheet, error) {
+	selected := []*importSheet{}
+	if len(selection) == 0 {
+		for i, sheet := range sheets {
+			if !sheet.Hidden {
+				selected = append(selected, &importSheet{Sheet: sheet, index: i})
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("file has no visible sheets")
+		}
+		return selected, nil
+	}
+
+	used := map[int]bool{}
+	for _, name := range selection {
+		index := findSheet(sheets, name)
+		if index < 0 {
+			return nil, fmt.Errorf("sheet %q is not found", name)
+		}
+		if used[index] {
+			return nil, fmt.Errorf("sheet %q is selected twice", name)
+		}
+		used[index] = true
+		selected = append(selected, &importSheet{Sheet: sheets[index], index: index})
+	}
+	return selected, nil
+}
+
+func findSheet(sheets []*xlsx.Sheet, name string) int {
+	for i, sheet := range sheets {
+		if sheet.Name == name {
+			return i
+		}
+	}
+	if number, err := strconv.Atoi(name); err == nil && number >= 1 && number <= len(sheets) {
+		return number - 1
+	}
+	return -1
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// startJob регистрирует новую задачу, если сервис не останавливается
+func (c *Controller) startJob() bool {
+	c.lifecycleMutex.Lock()
+	defer c.lifecycleMutex.Unlock()
+	if c.stopping {
+		return false
+	}
+	c.running.Add(1)
+	atomic.AddInt64(&c.activeJobs, 1)
+	return true
+}
+
+func (c *Controller) finishRunning() {
+	atomic.AddInt64(&c.activeJobs, -1)
+	c.running.Done()
+}
+
+func (c *Controller) isStopping() bool {
+	c.lifecycleMutex.Lock()
+	defer c.lifecycleMutex.Unlock()
+	return c.stopping
+}
+
+// Shutdown перестаёт принимать загрузки и ждёт запущенные задачи не дольше timeout,
+// после чего оставшиеся задачи прерываются и завершаются со статусом interrupted
+func (c *Controller) Shutdown(timeout time.Duration) {
+	c.lifecycleMutex.Lock()
+	c.stopping = true
+	c.lifecycleMutex.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+		c.Logger.Warn("shutdown timeout exceeded, interrupting running jobs", "timeout", timeout.String())
+		c.cancel()
+		<-finished
+	}
+	c.cancel()
+	c.cleanTempFiles()
+}
+
+// cleanTempFiles удаляет недописанные загрузки: файлы /send и тела POST /offers/bulk, а также копии,
+// не успевшие переехать в хранилище с другой файловой системы
+func (c *Controller) cleanTempFiles() {
+	files := []string{}
+	for _, pattern := range []string{
+		filepath.Join(c.Config.TempDir, "upload-*.xlsx"),
+		filepath.Join(c.Config.TempDir, "upload-*"+bulkExtension),
+		filepath.Join(c.Config.StorageDir, "*.tmp"),
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			c.Logger.Error("error in searching temp files", "error", err)
+			return
+		}
+		files = append(files, matches...)
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			c.Logger.Error("error in deleting file", "file", file, "error", err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package fixtures
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/tealeg/xlsx"
+	"strconv"
+	"strings"
+)
+
+// Sheet - лист прайс-листа, каждая строка - значения ячеек по порядку колонок
+type Sheet struct {
+	Name string
+	Rows [][]string
+	//скрытый лист, как инструкция или служебные данные, которые не нужно импортировать
+	Hidden bool
+}
+
+// Offer возвращает строку прайс-листа в порядке колонок импорта: offer id, название, цена, количество, доступность
+func Offer(offerId int64, name string, price int, quantity int, available bool) []string {
+	return []string{
+		strconv.FormatInt(offerId, 10),
+		name,
+		strconv.Itoa(price),
+		strconv.Itoa(quantity),
+		strconv.FormatBool(available),
+	}
+}
+
+// Offers генерирует count корректных доступных товаров с offer id, начиная с from
+func Offers(from int64, count int) [][]string {
+	rows := make([][]string, count)
+	for i := range rows {
+		offerId := from + int64(i)
+		rows[i] = Offer(offerId, fmt.Sprintf("product %v", offerId), 100+i, i+1, true)
+	}
+	return rows
+}
+
+// formulaSeparator отделяет формулу от её сохранённого значения в ячейке, собранной Formula
+const formulaSeparator = "\x00"
+
+// Formula возвращает значение ячейки с формулой и её сохранённым при последнем пересчёте значением,
+// пустое значение - формула, которую ни разу не пересчитывали
+func Formula(formula string, cached string) string {
+	return "=" + formula + formulaSeparator + cached
+}
+
+// Build собирает xlsx файл из листов. Числа записываются числовыми ячейками, целые - как их сохраняет Excel,
+// дробные - текстом как есть (например 100.0, как их сохраняют некоторые выгрузки), ячейки из Formula -
+// формулами, остальные значения - строками; листы без имени называются Sheet1, Sheet2 и так далее
+func Build(sheets ...Sheet) ([]byte, error) {
+	file := xlsx.NewFile()
+	for i, fixture := range sheets {
+		name := fixture.Name
+		if name == "" {
+			name = fmt.Sprintf("Sheet%v", i+1)
+		}
+		sheet, err := file.AddSheet(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, values := range fixture.Rows {
+			row := sheet.AddRow()
+			for _, value := range values {
+				cell := row.AddCell()
+				if formula, cached, ok := strings.Cut(value, formulaSeparator); ok {
+					cell.SetFormula(strings.TrimPrefix(formula, "="))
+					cell.Value = cached
+				} else if number, err := strconv.Atoi(value); err == nil {
+					cell.SetInt(number)
+				} else if number, err := strconv.ParseFloat(value, 64); err == nil {
+					cell.SetFloat(number)
+					cell.Value = value
+				} else {
+					cell.SetString(value)
+				}
+			}
+		}
+	}
+
+	parts, err := file.MarshallParts()
+	if err != nil {
+		return nil, err
+	}
+	//xlsx всегда записывает листы видимыми, скрытые отмечаются в описании книги вручную
+	for i, fixture := range sheets {
+		if fixture.Hidden {
+			parts["xl/workbook.xml"] = hideSheet(parts["xl/workbook.xml"], file.Sheets[i].Name)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(buf)
+	for name, part := range parts {
+		w, err := zipWriter.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(part)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func hideSheet(workbook string, name string) string {
+	start := strings.Index(workbook, fmt.Sprintf(`<sheet name="%v"`, name))
+	if start < 0 {
+		return workbook
+	}
+	end := start + strings.Index(workbook[start:], ">")
+	sheet := strings.Replace(workbook[start:end], `state="visible"`, `state="hidden"`, 1)
+	return workbook[:start] + sheet + workbook[end:]
+}
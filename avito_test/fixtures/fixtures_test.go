@@ -0,0 +1,64 @@
+package fixtures
+
+import (
+	"github.com/tealeg/xlsx"
+	"reflect"
+	"testing"
+)
+
+func TestBuildReadsBack(t *testing.T) {
+	sheets := []Sheet{
+		{Name: "prices", Rows: Offers(1, 3)},
+		{Rows: [][]string{Offer(7, "broken", -1, 0, false), {"abc", "", "12x"}, {"100.0", "1e2"}}, Hidden: true},
+	}
+	content, err := Build(sheets...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := xlsx.OpenBinary(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Sheets) != 2 || file.Sheets[0].Name != "prices" || file.Sheets[1].Name != "Sheet2" {
+		t.Fatalf("unexpected sheets: %v", file.Sheets)
+	}
+	if file.Sheets[0].Hidden || !file.Sheets[1].Hidden {
+		t.Errorf("got hidden %v, %v want false, true", file.Sheets[0].Hidden, file.Sheets[1].Hidden)
+	}
+	if cell := file.Sheets[1].Rows[2].Cells[0]; cell.Type() != xlsx.CellTypeNumeric {
+		t.Errorf("fractional number is written as %v cell", cell.Type())
+	}
+	for i, sheet := range file.Sheets {
+		rows := [][]string{}
+		for _, row := range sheet.Rows {
+			values := []string{}
+			for _, cell := range row.Cells {
+				values = append(values, cell.Value)
+			}
+			rows = append(rows, values)
+		}
+		if !reflect.DeepEqual(rows, sheets[i].Rows) {
+			t.Errorf("sheet %v: got %v want %v", i+1, rows, sheets[i].Rows)
+		}
+	}
+}
+
+func TestBuildFormulas(t *testing.T) {
+	content, err := Build(Sheet{Rows: [][]string{{"2", Formula("A1*50", "100"), Formula("A1*3", "")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := xlsx.OpenBinary(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells := file.Sheets[0].Rows[0].Cells
+	if cells[1].Formula() != "A1*50" || cells[1].Value != "100" {
+		t.Errorf("got formula %q with value %q", cells[1].Formula(), cells[1].Value)
+	}
+	if cells[2].Formula() != "A1*3" || cells[2].Value != "" {
+		t.Errorf("got formula %q with value %q", cells[2].Formula(), cells[2].Value)
+	}
+}
@@ -0,0 +1,677 @@
+package main
+
+import (
+	"avito_test/config"
+	"avito_test/controller"
+	"avito_test/fixtures"
+	"avito_test/jobs"
+	"avito_test/model"
+	"avito_test/storage"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newImportServer - тестовый сервер, который сохраняет загрузки во временные директории теста
+func newImportServer(t *testing.T) (http.Handler, *controller.Controller) {
+	cfg := config.Default()
+	cfg.TempDir = t.TempDir()
+	cfg.StorageDir = t.TempDir()
+	return newTestServer(t, cfg)
+}
+
+// runImport загружает файл через роутер и ждёт завершения задачи
+func runImport(t *testing.T, m http.Handler, c *controller.Controller, seller int64, sheets ...fixtures.Sheet) *jobs.Job {
+	return uploadAndWait(t, m, c, newUploadRequest(t, seller, "prices.xlsx", buildFixture(t, sheets...)))
+}
+
+func buildFixture(t *testing.T, sheets ...fixtures.Sheet) []byte {
+	content, err := fixtures.Build(sheets...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func uploadAndWait(t *testing.T, m http.Handler, c *controller.Controller, req *http.Request) *jobs.Job {
+	rr := serve(m, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload returned wrong status code: got %v, %v", rr.Code, rr.Body.String())
+	}
+	job, ok := c.Jobs.Get(rr.Body.String())
+	if !ok {
+		t.Fatalf("job %v is not registered", rr.Body.String())
+	}
+	select {
+	case <-job.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("job %v did not finish, status: %v", job.Id, job.Status())
+	}
+	return job
+}
+
+func sellerProducts(t *testing.T, c *controller.Controller, seller int64) []*model.Product {
+	products, err := c.Store.FindProducts(context.Background(), storage.ProductFilter{SellerId: &seller})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return products
+}
+
+// expectedProducts переводит строки фикстуры в товары, которые должны оказаться в хранилище
+func expectedProducts(seller int64, rows [][]string) []*model.Product {
+	products := []*model.Product{}
+	for _, row := range rows {
+		product := &model.Product{
+			SellerId: seller, Name: row[1], Currency: model.DefaultCurrency, Available: true, CreatedAt: testNow, UpdatedAt: testNow,
+		}
+		fmt.Sscan(row[0], &product.OfferId)
+		product.Price, _ = model.ParseMoney(row[2])
+		fmt.Sscan(row[3], &product.Quantity)
+		if len(row) > 5 {
+			product.Currency = row[5]
+		}
+		products = append(products, product)
+	}
+	return products
+}
+
+func finishedStatus(created int, deleted int, errors ...string) string {
+	return fmt.Sprintf(
+		"finished with result: created or updated - %v,\ndeleted - %v,\nerrors - %v",
+		created,
+		deleted,
+		strings.Join(errors, ",\n"),
+	)
+}
+
+func TestImportValidRows(t *testing.T) {
+	m, c := newImportServer(t)
+	rows := fixtures.Offers(1, 5)
+
+	job := runImport(t, m, c, 1, fixtures.Sheet{Rows: rows})
+
+	if job.Status() != finishedStatus(5, 0) {
+		t.Errorf("got status %q want %q", job.Status(), finishedStatus(5, 0))
+	}
+	if products := sellerProducts(t, c, 1); !reflect.DeepEqual(products, expectedProducts(1, rows)) {
+		t.Errorf("got products %+v want %+v", products, expectedProducts(1, rows))
+	}
+	progress := job.Progress.Snapshot(time.Now())
+	if progress.TotalRows != 5 || progress.ProcessedRows != 5 || progress.Percent != 100 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+}
+
+func TestImportRejectsBadRows(t *testing.T) {
+	m, c := newImportServer(t)
+	rows := [][]string{
+		fixtures.Offer(1, "good", 10, 1, true),
+		{"abc", "bad offer id", "10", "1", "true"},
+		{"0", "zero offer id", "10", "1", "true"},
+		{"2", "bad price", "12x", "1", "true"},
+		fixtures.Offer(3, "negative price", -5, 1, true),
+		{"4", "bad quantity", "10", "many", "true"},
+		fixtures.Offer(5, "negative quantity", 10, -1, true),
+		{"6", "bad available", "10", "1", "maybe"},
+		fixtures.Offer(7, "good", 20, 2, true),
+		{"2147483648", "offer id out of integer", "10", "1", "false"},
+	}
+
+	job := runImport(t, m, c, 1, fixtures.Sheet{Rows: rows})
+
+	expected := finishedStatus(2, 0,
+		`sheet 1, row 2: offer id is not a number, err: strconv.Atoi: parsing "abc": invalid syntax`,
+		"sheet 1, row 3: offer id lower or equals zero",
+		`sheet 1, row 4: price is not a number, err: parsing "12x": invalid price`,
+		"sheet 1, row 5: price lower than zero",
+		`sheet 1, row 6: quantity is not a number, err: strconv.Atoi: parsing "many": invalid syntax`,
+		"sheet 1, row 7: quantity lower than zero",
+		`sheet 1, row 8: error in parsing available: strconv.ParseBool: parsing "maybe": invalid syntax`,
+		"sheet 1, row 10: offer id is too large",
+	)
+	if job.Status() != expected {
+		t.Errorf("got status %q want %q", job.Status(), expected)
+	}
+	good := [][]string{rows[0], rows[8]}
+	if products := sellerProducts(t, c, 1); !reflect.DeepEqual(products, expectedProducts(1, good)) {
+		t.Errorf("got products %+v want %+v", products, expectedProducts(1, good))
+	}
+}
+
+func TestImportUpdatesAndDeletesExisting(t *testing.T) {
+	m, c := newImportServer(t)
+	seedProducts(t, c.Store,
+		&model.Product{SellerId: 9, OfferId: 1, Name: "apple", Price: 5000, Currency: "RUB", Quantity: 1},
+		&model.Product{SellerId: 9, OfferId: 3, Name: "old", Price: 100, Currency: "RUB", Quantity: 1},
+		&model.Product{SellerId: 10, OfferId: 3, Name: "other seller", Price: 100, Currency: "RUB", Quantity: 1},
+	)
+
+	job := runImport(t, m, c, 9, fixtures.Sheet{Rows: [][]string{
+		fixtures.Offer(1, "apple", 100, 5, true),
+		fixtures.Offer(2, "pear", -1, 1, true),
+		fixtures.Offer(3, "old", 1, 1, false),
+	}})
+
+	expected := finishedStatus(1, 1, "sheet 1, row 2: price lower than zero")
+	if job.Status() != expected {
+		t.Errorf("got status %q want %q", job.Status(), expected)
+	}
+	rr := serve(m, httptest.NewRequest("GET", "/offers?seller=9", nil))
+	if rr.Body.String() != `[{"seller_id":9,"offer_id":1,"name":"apple","price":"100.00","currency":"RUB","quantity":5,"available":true,`+testTimestamps+`}]` {
+		t.Errorf("unexpected offers: %v", rr.Body.String())
+	}
+	if products := sellerProducts(t, c, 10); len(products) != 1 {
+		t.Errorf("products of another seller are changed: %+v", products)
+	}
+}
+
+func TestImportMultipleSheets(t *testing.T) {
+	m, c := newImportServer(t)
+	first := fixtures.Offers(1, 3)
+	second := append(fixtures.Offers(10, 2), fixtures.Offer(12, "broken", -1, 1, true))
+
+	job := runImport(t, m, c, 1,
+		fixtures.Sheet{Name: "fruits", Rows: first},
+		fixtures.Sheet{Name: "vegetables", Rows: second},
+	)
+
+	expected := finishedStatus(5, 0, "sheet 2, row 3: price lower than zero")
+	if job.Status() != expected {
+		t.Errorf("got status %q want %q", job.Status(), expected)
+	}
+	all := append(append([][]string{}, first...), second[:2]...)
+	if products := sellerProducts(t, c, 1); !reflect.DeepEqual(products, expectedProducts(1, all)) {
+		t.Errorf("got products %+v want %+v", products, expectedProducts(1, all))
+	}
+	progress := job.Progress.Snapshot(time.Now())
+	if len(progress.Sheets) != 2 || progress.Sheets[0].Name != "fruits" || progress.Sheets[1].ProcessedRows != 3 {
+		t.Errorf("unexpected sheets progress: %+v", progress.Sheets)
+	}
+}
+
+// TestImportBatchBoundaries проверяет, что строки делятся на пачки по BatchSize без потерь и повторов
+func TestImportBatchBoundaries(t *testing.T) {
+	for _, count := range []int{1, 99, 100, 101, 250} {
+		t.Run(fmt.Sprint(count), func(t *testing.T) {
+			m, c := newImportServer(t)
+			rows := fixtures.Offers(1, count)
+
+			job := runImport(t, m, c, 1, fixtures.Sheet{Rows: rows})
+
+			if job.Status() != finishedStatus(count, 0) {
+				t.Errorf("got status %q want %q", job.Status(), finishedStatus(count, 0))
+			}
+			if products := sellerProducts(t, c, 1); !reflect.DeepEqual(products, expectedProducts(1, rows)) {
+				t.Errorf("got %v products want %v", len(products), count)
+			}
+			checkpoints, err := c.Store.Checkpoints(context.Background(), job.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			batches := (count + c.Config.BatchSize - 1) / c.Config.BatchSize
+			if len(checkpoints) != batches {
+				t.Errorf("got %v committed batches want %v", len(checkpoints), batches)
+			}
+			if progress := job.Progress.Snapshot(time.Now()); progress.ProcessedRows != int64(count) {
+				t.Errorf("got %v processed rows want %v", progress.ProcessedRows, count)
+			}
+		})
+	}
+}
+
+// TestImportTempDirOnAnotherFilesystem проверяет загрузку, когда временная директория и хранилище на разных
+// файловых системах и rename между ними невозможен, как с отдельным volume для хранилища в docker-compose
+func TestImportTempDirOnAnotherFilesystem(t *testing.T) {
+	tempDir, err := ioutil.TempDir("/dev/shm", "avito-temp-")
+	if err != nil {
+		t.Skipf("no tmpfs for temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	cfg := config.Default()
+	cfg.TempDir = tempDir
+	cfg.StorageDir = t.TempDir()
+	probe := filepath.Join(tempDir, "probe")
+	if err := ioutil.WriteFile(probe, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(probe, filepath.Join(cfg.StorageDir, "probe")); err == nil {
+		t.Skip("temp dir and storage dir are on the same filesystem")
+	}
+	os.Remove(probe)
+	m, c := newTestServer(t, cfg)
+
+	rows := fixtures.Offers(1, 3)
+	job := runImport(t, m, c, 1, fixtures.Sheet{Rows: rows})
+
+	if job.Status() != finishedStatus(3, 0) {
+		t.Errorf("got status %q", job.Status())
+	}
+	if products := sellerProducts(t, c, 1); !reflect.DeepEqual(products, expectedProducts(1, rows)) {
+		t.Errorf("got products %+v", products)
+	}
+	if left, _ := filepath.Glob(filepath.Join(tempDir, "*")); len(left) != 0 {
+		t.Errorf("temp files left: %v", left)
+	}
+	if left, _ := filepath.Glob(filepath.Join(cfg.StorageDir, "*.tmp")); len(left) != 0 {
+		t.Errorf("partial copies left in storage: %v", left)
+	}
+}
+
+// TestImportResume проверяет, что задача импорта xlsx после падения продолжается с незакоммиченной пачки
+func TestImportResume(t *testing.T) {
+	cfg := config.Default()
+	cfg.StorageDir = t.TempDir()
+	cfg.BatchSize = 2
+	store := storage.NewMemory()
+	store.Now = func() time.Time {
+		return testNow
+	}
+	ctx := context.Background()
+
+	rows := fixtures.Offers(1, 5)
+	filePath := filepath.Join(cfg.StorageDir, "xlsx-job.xlsx")
+	if err := ioutil.WriteFile(filePath, buildFixture(t, fixtures.Sheet{Rows: rows}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertJob(ctx, &storage.StoredJob{Id: "xlsx-job", SellerId: 1, FilePath: filePath, BatchSize: 2}, "working with rows"); err != nil {
+		t.Fatal(err)
+	}
+	//первая пачка была закоммичена до падения, её товары повторно не пишутся
+	committed := []*model.Product{
+		{OfferId: 1, Name: "before crash", Price: 100, Currency: "RUB", Quantity: 1},
+		{OfferId: 2, Name: "before crash", Price: 100, Currency: "RUB", Quantity: 1},
+	}
+	err := store.SaveBatch(ctx, "xlsx-job", jobs.BatchKey{}, &storage.Batch{SellerId: 1, Upsert: committed}, &jobs.Checkpoint{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, c := newServer(store, cfg, testLogger)
+	if err := c.ResumeJobs(); err != nil {
+		t.Fatal(err)
+	}
+	job, ok := c.Jobs.Get("xlsx-job")
+	if !ok {
+		t.Fatal("job is not resumed")
+	}
+	select {
+	case <-job.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("job did not finish, status: %v", job.Status())
+	}
+	if job.Status() != finishedStatus(5, 0) {
+		t.Errorf("got status %q", job.Status())
+	}
+	expected := append(committed, expectedProducts(1, rows[2:])...)
+	for _, product := range committed {
+		product.SellerId, product.Available, product.CreatedAt, product.UpdatedAt = 1, true, testNow, testNow
+	}
+	if products := sellerProducts(t, c, 1); !reflect.DeepEqual(products, expected) {
+		t.Errorf("got products %+v want committed batch untouched %+v", products, expected)
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Errorf("stored file is not deleted: %v", err)
+	}
+}
+
+// TestImportIrregularRows проверяет строки, которые не похожи на аккуратную таблицу: короткие, пустые,
+// с формулами и числами, сохранёнными как дробные
+func TestImportIrregularRows(t *testing.T) {
+	m, c := newImportServer(t)
+
+	job := runImport(t, m, c, 1, fixtures.Sheet{Rows: [][]string{
+		fixtures.Offer(1, "apple", 100, 5, true),
+		{"2", "short"},
+		{},
+		{"", "", "", "", ""},
+		{"3.0", "float", "100.0", "2.0", "true"},
+		{"4", "formula", fixtures.Formula("C1*2", "200"), "1", "true"},
+		{"5", "not calculated", fixtures.Formula("C1*3", ""), "1", "true"},
+		{"6"},
+		{},
+		{"", ""},
+	}})
+
+	expected := finishedStatus(3, 0,
+		`sheet 1, row 2: error in parsing available: strconv.ParseBool: parsing "": invalid syntax`,
+		"sheet 1, row 7: formula in column 3 has no calculated value, recalculate and save the file",
+		`sheet 1, row 8: error in parsing available: strconv.ParseBool: parsing "": invalid syntax`,
+	)
+	if job.Status() != expected {
+		t.Errorf("got status %q want %q", job.Status(), expected)
+	}
+	rows := [][]string{
+		fixtures.Offer(1, "apple", 100, 5, true),
+		fixtures.Offer(3, "float", 100, 2, true),
+		fixtures.Offer(4, "formula", 200, 1, true),
+	}
+	if products := sellerProducts(t, c, 1); !reflect.DeepEqual(products, expectedProducts(1, rows)) {
+		t.Errorf("got products %+v want %+v", products, expectedProducts(1, rows))
+	}
+	if progress := job.Progress.Snapshot(time.Now()); progress.ProcessedRows != 10 {
+		t.Errorf("got %v processed rows want 10", progress.ProcessedRows)
+	}
+}
+
+func TestImportDecimalPrices(t *testing.T) {
+	m, c := newImportServer(t)
+
+	job := runImport(t, m, c, 1, fixtures.Sheet{Rows: [][]string{
+		{"1", "numeric", "199.9", "1", "true"},
+		{"2", "russian", "1 299,90 ₽", "1", "true"},
+		{"3", "dollars", "$1,299.99", "1", "true"},
+		{"4", "column", "15,5", "1", "true", "eur"},
+		{"5", "conflict", "15 €", "1", "true", "USD"},
+		{"6", "kopecks", "0.005", "1", "true"},
+		{"7", "ambiguous", "19.990 ₽", "1", "true"},
+	}})
+
+	expected := finishedStatus(4, 0,
+		"sheet 1, row 5: price currency EUR differs from currency column USD",
+		`sheet 1, row 6: price is not a number, err: parsing "0.005": price must have at most 2 decimal places`,
+		`sheet 1, row 7: price is not a number, err: parsing "19.990 ₽": ambiguous price, separator may be decimal or thousands one`,
+	)
+	if job.Status() != expected {
+		t.Errorf("got status %q want %q", job.Status(), expected)
+	}
+	rr := serve(m, httptest.NewRequest("GET", "/offers?seller=1", nil))
+	offers := `[{"seller_id":1,"offer_id":1,"name":"numeric","price":"199.90","currency":"RUB","quantity":1,"available":true,` + testTimestamps + `},` +
+		`{"seller_id":1,"offer_id":2,"name":"russian","price":"1299.90","currency":"RUB","quantity":1,"available":true,` + testTimestamps + `},` +
+		`{"seller_id":1,"offer_id":3,"name":"dollars","price":"1299.99","currency":"USD","quantity":1,"available":true,` + testTimestamps + `},` +
+		`{"seller_id":1,"offer_id":4,"name":"column","price":"15.50","currency":"EUR","quantity":1,"available":true,` + testTimestamps + `}]`
+	if rr.Body.String() != offers {
+		t.Errorf("got offers %v want %v", rr.Body.String(), offers)
+	}
+}
+
+func TestImportProductAttributes(t *testing.T) {
+	m, c := newImportServer(t)
+
+	job := runImport(t, m, c, 1, fixtures.Sheet{Rows: [][]string{
+		{
+			"1", "Груша", "30", "3", "true", "", "Сочная груша", "Фрукты / Груши", "Сад", "4.006381333931E+12",
+			"https://img.example.com/pear.jpg", "180", "60", "60", "90",
+		},
+		{"2", "Яблоко", "10", "1", "true", "", "", "", "", "4006381333932"},
+		{"3", strings.Repeat("я", 256), "10", "1", "true"},
+	}})
+
+	expected := finishedStatus(1, 0,
+		`sheet 1, row 2: barcode "4006381333932" is not a valid EAN-8, UPC-A, EAN-13 or GTIN-14`,
+		"sheet 1, row 3: name is longer than 255 characters",
+	)
+	if job.Status() != expected {
+		t.Errorf("got status %q want %q", job.Status(), expected)
+	}
+	rr := serve(m, httptest.NewRequest("GET", "/offers?seller=1", nil))
+	offers := `[{"seller_id":1,"offer_id":1,"name":"Груша","price":"30.00","currency":"RUB","quantity":3,"available":true,` +
+		`"description":"Сочная груша","category":["Фрукты","Груши"],"brand":"Сад","barcode":"4006381333931",` +
+		`"images":["https://img.example.com/pear.jpg"],"weight":180,"length":60,"width":60,"height":90,` + testTimestamps + `}]`
+	if rr.Body.String() != offers {
+		t.Errorf("got offers %v want %v", rr.Body.String(), offers)
+	}
+}
+
+// TestImportDuplicateOffers проверяет повторы offer id внутри одной пачки и в разных пачках листа
+func TestImportDuplicateOffers(t *testing.T) {
+	for _, test := range []struct {
+		policy   string
+		expected [][]string
+		errors   []string
+	}{
+		{
+			policy:   config.DuplicateFirst,
+			expected: [][]string{fixtures.Offer(1, "first", 10, 1, true), fixtures.Offer(2, "pear", 5, 5, true)},
+			errors: []string{
+				"sheet 1, row 2: offer id 1 is duplicated, first occurrence at sheet 1, row 1 is used",
+				"sheet 1, row 4: offer id 1 is duplicated, first occurrence at sheet 1, row 1 is used",
+			},
+		},
+		{
+			policy:   config.DuplicateLast,
+			expected: [][]string{fixtures.Offer(1, "last", 30, 3, true), fixtures.Offer(2, "pear", 5, 5, true)},
+			errors: []string{
+				"sheet 1, row 1: offer id 1 is duplicated, last occurrence at sheet 1, row 4 is used",
+				"sheet 1, row 2: offer id 1 is duplicated, last occurrence at sheet 1, row 4 is used",
+			},
+		},
+		{
+			policy:   config.DuplicateReject,
+			expected: [][]string{fixtures.Offer(2, "pear", 5, 5, true)},
+			errors: []string{
+				"sheet 1, row 1: offer id 1 is duplicated at sheet 1, row 2 and sheet 1, row 4, all occurrences are rejected",
+				"sheet 1, row 2: offer id 1 is duplicated at sheet 1, row 1 and sheet 1, row 4, all occurrences are rejected",
+				"sheet 1, row 4: offer id 1 is duplicated at sheet 1, row 1 and sheet 1, row 2, all occurrences are rejected",
+			},
+		},
+	} {
+		t.Run(test.policy, func(t *testing.T) {
+			m, c := newImportServer(t)
+			c.Config.DuplicateOffers = test.policy
+			c.Config.BatchSize = 2
+
+			job := runImport(t, m, c, 1, fixtures.Sheet{Rows: [][]string{
+				fixtures.Offer(1, "first", 10, 1, true),
+				fixtures.Offer(1, "second", 20, 2, true),
+				fixtures.Offer(2, "pear", 5, 5, true),
+				fixtures.Offer(1, "last", 30, 3, true),
+				//строка с ошибкой не считается повтором
+				fixtures.Offer(2, "broken", -1, 1, true),
+			}})
+
+			errors := job.Progress.ErrorStrings()
+			sort.Strings(errors)
+			expectedErrors := append(test.errors, "sheet 1, row 5: price lower than zero")
+			sort.Strings(expectedErrors)
+			if !reflect.DeepEqual(errors, expectedErrors) {
+				t.Errorf("got errors %q want %q", errors, expectedErrors)
+			}
+			if products := sellerProducts(t, c, 1); !reflect.DeepEqual(products, expectedProducts(1, test.expected)) {
+				t.Errorf("got products %+v want %+v", products, expectedProducts(1, test.expected))
+			}
+		})
+	}
+}
+
+// TestImportDuplicatesAcrossSheets проверяет, что повторы на разных листах ищутся по всему файлу
+// в порядке обработки листов: при last побеждает поздний лист, при first - ранний
+func TestImportDuplicatesAcrossSheets(t *testing.T) {
+	sheets := []fixtures.Sheet{
+		{Rows: fixtures.Offers(1, 5)},
+		{Rows: [][]string{
+			fixtures.Offer(2, "updated", 1, 1, true),
+			fixtures.Offer(3, "deleted", 1, 1, false),
+		}},
+	}
+	all := fixtures.Offers(1, 5)
+	for _, test := range []struct {
+		policy   string
+		status   string
+		expected [][]string
+	}{
+		{
+			policy: config.DuplicateLast,
+			status: finishedStatus(4, 0,
+				"sheet 1, row 2: offer id 2 is duplicated, last occurrence at sheet 2, row 1 is used",
+				"sheet 1, row 3: offer id 3 is duplicated, last occurrence at sheet 2, row 2 is used",
+			),
+			expected: [][]string{all[0], fixtures.Offer(2, "updated", 1, 1, true), all[3], all[4]},
+		},
+		{
+			policy: config.DuplicateFirst,
+			status: finishedStatus(5, 0,
+				"sheet 2, row 1: offer id 2 is duplicated, first occurrence at sheet 1, row 2 is used",
+				"sheet 2, row 2: offer id 3 is duplicated, first occurrence at sheet 1, row 3 is used",
+			),
+			expected: all,
+		},
+	} {
+		t.Run(test.policy, func(t *testing.T) {
+			m, c := newImportServer(t)
+			c.Config.DuplicateOffers = test.policy
+
+			job := runImport(t, m, c, 1, sheets...)
+
+			if job.Status() != test.status {
+				t.Errorf("got status %q want %q", job.Status(), test.status)
+			}
+			if products := sellerProducts(t, c, 1); !reflect.DeepEqual(products, expectedProducts(1, test.expected)) {
+				t.Errorf("got products %+v want %+v", products, expectedProducts(1, test.expected))
+			}
+		})
+	}
+}
+
+func TestImportSheetSelection(t *testing.T) {
+	content := buildFixture(t,
+		fixtures.Sheet{Name: "readme", Rows: [][]string{{"Fill the prices sheet"}}, Hidden: true},
+		fixtures.Sheet{Name: "prices", Rows: [][]string{fixtures.Offer(1, "price list", 10, 1, true)}},
+		fixtures.Sheet{Name: "2", Rows: [][]string{fixtures.Offer(1, "sheet named 2", 20, 2, true)}},
+		fixtures.Sheet{Name: "sale", Rows: [][]string{fixtures.Offer(1, "sale", 5, 1, true)}},
+	)
+
+	for _, test := range []struct {
+		name     string
+		sheets   string
+		expected string
+		status   string
+	}{
+		{name: "visible sheets", expected: "sale", status: finishedStatus(1, 0,
+			"sheet 2, row 1: offer id 1 is duplicated, last occurrence at sheet 4, row 1 is used",
+			"sheet 3, row 1: offer id 1 is duplicated, last occurrence at sheet 4, row 1 is used",
+		)},
+		{name: "by name", sheets: "prices", expected: "price list", status: finishedStatus(1, 0)},
+		{name: "name before index", sheets: "sale, 2", expected: "sheet named 2", status: finishedStatus(1, 0,
+			"sheet 4, row 1: offer id 1 is duplicated, last occurrence at sheet 3, row 1 is used",
+		)},
+		{name: "by index", sheets: "4,3", expected: "sheet named 2", status: finishedStatus(1, 0,
+			"sheet 4, row 1: offer id 1 is duplicated, last occurrence at sheet 3, row 1 is used",
+		)},
+		{name: "selected hidden", sheets: "readme", status: finishedStatus(0, 0,
+			`sheet 1, row 1: offer id is not a number, err: strconv.Atoi: parsing "Fill the prices sheet": invalid syntax`,
+		)},
+		{name: "unknown", sheets: "prices,stock", status: `error: sheet "stock" is not found`},
+		{name: "twice", sheets: "prices,2,3", status: `error: sheet "3" is selected twice`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m, c := newImportServer(t)
+			req := newUploadRequest(t, 1, "prices.xlsx", content)
+			req.URL.RawQuery += "&sheets=" + url.QueryEscape(test.sheets)
+
+			job := uploadAndWait(t, m, c, req)
+
+			if job.Status() != test.status {
+				t.Errorf("got status %q want %q", job.Status(), test.status)
+			}
+			products := sellerProducts(t, c, 1)
+			if test.expected == "" && len(products) != 0 || test.expected != "" && (len(products) != 1 || products[0].Name != test.expected) {
+				t.Errorf("got products %+v want %q", products, test.expected)
+			}
+		})
+	}
+}
+
+func TestImportSampleFile(t *testing.T) {
+	m, c := newImportServer(t)
+	content, err := ioutil.ReadFile("temp_files/test.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := uploadAndWait(t, m, c, newUploadRequest(t, 1, "prices.xlsx", content))
+
+	if !strings.HasPrefix(job.Status(), "finished with result") {
+		t.Fatalf("unexpected status: %v", job.Status())
+	}
+	progress := job.Progress.Snapshot(time.Now())
+	if progress.TotalRows != 602 || progress.ProcessedRows != 602 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+	created := job.Progress.Created()
+	if products := sellerProducts(t, c, 1); int64(len(products)) != created || created == 0 {
+		t.Errorf("got %v products, job reported %v", len(products), created)
+	}
+}
+
+// TestExportRoundTrip выгружает каталог продавца и загружает выгрузку другому продавцу, каталоги должны совпасть
+func TestExportRoundTrip(t *testing.T) {
+	m, c := newImportServer(t)
+	rows := append(fixtures.Offers(1, 120), [][]string{
+		{
+			"200", "Груша", "1 299,90 ₽", "3", "true", "", "Сочная груша", "Фрукты / Груши", "Сад", "4006381333931",
+			"https://img.example.com/1.jpg; https://img.example.com/2.jpg", "180", "", "60", "90",
+		},
+		{"201", "Яблоко, \"красное\"", "$0.99", "0", "true", "", "в несколько\nстрок"},
+		{"202", "unavailable", "1", "1", "false"},
+	}...)
+	if job := runImport(t, m, c, 1, fixtures.Sheet{Rows: rows}); job.Status() != finishedStatus(122, 0) {
+		t.Fatalf("got status %q want %q", job.Status(), finishedStatus(122, 0))
+	}
+
+	rr := serve(m, httptest.NewRequest("GET", "/offers/export?seller=1&format=xlsx", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Disposition") != `attachment; filename="offers-1.xlsx"` {
+		t.Fatalf("export returned %v, %v", rr.Code, rr.Header())
+	}
+	job := uploadAndWait(t, m, c, newUploadRequest(t, 2, "offers-1.xlsx", rr.Body.Bytes()))
+	if job.Status() != finishedStatus(122, 0) {
+		t.Errorf("got status %q want %q", job.Status(), finishedStatus(122, 0))
+	}
+
+	exported := sellerProducts(t, c, 1)
+	imported := sellerProducts(t, c, 2)
+	for _, product := range imported {
+		product.SellerId = 1
+	}
+	if len(imported) != len(exported) {
+		t.Fatalf("got %v products want %v", len(imported), len(exported))
+	}
+	for i := range exported {
+		if !reflect.DeepEqual(imported[i], exported[i]) {
+			t.Errorf("got product %+v want %+v", imported[i], exported[i])
+		}
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	m, c := newImportServer(t)
+	seedProducts(t, c.Store,
+		&model.Product{SellerId: 1, OfferId: 1, Name: "apple", Price: 129990, Currency: "USD", Quantity: 5},
+		&model.Product{
+			SellerId: 1, OfferId: 2, Name: "Груша, сочная", Price: 3000, Currency: "RUB", Quantity: 3, Category: []string{"Фрукты", "Груши"},
+			Barcode: "4006381333931", Images: []string{"https://img.example.com/1.jpg", "https://img.example.com/2.jpg"}, Weight: 180,
+		},
+		&model.Product{SellerId: 2, OfferId: 1, Name: "other seller", Price: 100, Currency: "RUB", Quantity: 1},
+		&model.Product{SellerId: 1, OfferId: 3, Name: `=HYPERLINK("http://evil.example.com","click")`, Description: "-5% скидка", Brand: "@brand", Currency: "RUB"},
+		&model.Product{SellerId: 1, OfferId: 4, Name: "+1 в подарок", Description: "a=b, c-d", Brand: "Бренд @home", Currency: "RUB"},
+	)
+
+	rr := serve(m, httptest.NewRequest("GET", "/offers/export?seller=1&format=csv", nil))
+	expected := "1,apple,1299.90,5,true,USD,,,,,,,,,\n" +
+		`2,"Груша, сочная",30.00,3,true,RUB,,Фрукты > Груши,,4006381333931,https://img.example.com/1.jpg https://img.example.com/2.jpg,180,,,` + "\n" +
+		`3,"'=HYPERLINK(""http://evil.example.com"",""click"")",0.00,0,true,RUB,'-5% скидка,,'@brand,,,,,,` + "\n" +
+		`4,'+1 в подарок,0.00,0,true,RUB,"a=b, c-d",,Бренд @home,,,,,,` + "\n"
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv; charset=utf-8" || rr.Body.String() != expected {
+		t.Errorf("got %v, %v, %q want %q", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String(), expected)
+	}
+
+	rr = serve(m, httptest.NewRequest("GET", "/offers/export?seller=3&format=csv", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Disposition") != `attachment; filename="offers-3.csv"` || rr.Body.Len() != 0 {
+		t.Errorf("got %v, %v, %q for empty catalog", rr.Code, rr.Header(), rr.Body.String())
+	}
+
+	rr = serve(m, httptest.NewRequest("GET", "/offers/export?seller=1&format=pdf", nil))
+	if rr.Code != http.StatusInternalServerError || rr.Body.String() != "unsupported export format: pdf" {
+		t.Errorf("got %v, %v for unsupported format", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,15 @@
+package jobs
+
+// BatchKey определяет пачку строк по номеру листа и номеру пачки внутри листа
+type BatchKey struct {
+	Sheet int
+	Batch int
+}
+
+// Checkpoint - результат закоммиченной пачки, при возобновлении задачи пачка не обрабатывается повторно.
+// Deleted - число товаров, снятых с продажи: название осталось с тех пор, когда такие товары удалялись
+type Checkpoint struct {
+	Created      int64
+	Deleted      int64
+	ErrorStrings []string
+}
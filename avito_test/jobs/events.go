@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"avito_test/model"
+	"sync"
+	"time"
+)
+
+// maxEvents - сколько последних событий хранит задача. Ошибка каждой строки - отдельное событие,
+// поэтому без ограничения файл с миллионом плохих строк держал бы в памяти миллион событий
+const maxEvents = 1000
+
+// eventLog хранит последние maxEvents событий задачи в кольцевом буфере, чтобы подписчик, подключившийся позже,
+// получил их с начала. Вместо вытесненных событий подписчик получает одно событие truncated с их числом
+type eventLog struct {
+	mutex  sync.Mutex
+	events []*model.JobEvent
+	//start - индекс самого старого события в буфере, dropped - число вытесненных событий
+	start   int
+	dropped int
+	changed chan struct{}
+	closed  bool
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		changed: make(chan struct{}),
+	}
+}
+
+func (l *eventLog) publish(event *model.JobEvent) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return
+	}
+	event.Id = l.dropped + len(l.events) + 1
+	event.Time = time.Now()
+	if len(l.events) < maxEvents {
+		l.events = append(l.events, event)
+	} else {
+		l.events[l.start] = event
+		l.start = (l.start + 1) % maxEvents
+		l.dropped++
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// close публикует последнее событие и будит подписчиков, после него события не принимаются
+func (l *eventLog) close(event *model.JobEvent) {
+	l.publish(event)
+	l.mutex.Lock()
+	l.closed = true
+	l.mutex.Unlock()
+}
+
+func (l *eventLog) since(from int) ([]*model.JobEvent, <-chan struct{}, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	//from приходит от клиента в Last-Event-ID и может быть любым
+	total := l.dropped + len(l.events)
+	if from < 0 {
+		from = 0
+	}
+	if from > total {
+		from = total
+	}
+	events := make([]*model.JobEvent, 0, total-from+1)
+	if from < l.dropped {
+		//номер truncated - последнее вытесненное событие, с Last-Event-ID равным ему поток продолжится без повтора
+		events = append(events, &model.JobEvent{
+			Id:    l.dropped,
+			Time:  l.events[l.start].Time,
+			Type:  "truncated",
+			Count: int64(l.dropped - from),
+		})
+		from = l.dropped
+	}
+	for i := from - l.dropped; i < len(l.events); i++ {
+		events = append(events, l.events[(l.start+i)%len(l.events)])
+	}
+	return events, l.changed, l.closed
+}
@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEventsTruncated(t *testing.T) {
+	job := NewRegistry().Create(1)
+	job.SetStatus("working with sheets")
+	for i := 0; i < maxEvents+10; i++ {
+		job.Progress.AddError(fmt.Sprintf("row %v: price lower than zero", i+1))
+	}
+	job.Finish("finished")
+
+	//статус, maxEvents+10 ошибок и finished, из них в буфере последние maxEvents
+	const total = maxEvents + 12
+	events, _, closed := job.Events(0)
+	if !closed || len(events) != maxEvents+1 {
+		t.Fatalf("got %v events, closed %v want %v", len(events), closed, maxEvents+1)
+	}
+	if first := events[0]; first.Type != "truncated" || first.Id != total-maxEvents || first.Count != total-maxEvents {
+		t.Errorf("got first event %+v want truncated %v events", first, total-maxEvents)
+	}
+	for i, event := range events[1:] {
+		if event.Id != total-maxEvents+i+1 {
+			t.Fatalf("got event id %v at %v want %v", event.Id, i, total-maxEvents+i+1)
+		}
+	}
+	if last := events[len(events)-1]; last.Type != "finished" || last.Id != total {
+		t.Errorf("got last event %+v want finished", last)
+	}
+
+	//продолжение с номера truncated идёт без повтора, а с сохранённого события - без truncated
+	if events, _, _ := job.Events(total - maxEvents); len(events) != maxEvents || events[0].Type != "error" {
+		t.Errorf("got %v events from truncated id", len(events))
+	}
+	if events, _, _ := job.Events(total - 1); len(events) != 1 || events[0].Type != "finished" {
+		t.Errorf("got events %+v from the last but one", events)
+	}
+	if events, _, _ := job.Events(5); events[0].Type != "truncated" || events[0].Count != total-maxEvents-5 {
+		t.Errorf("got first event %+v from id 5", events[0])
+	}
+}
@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"avito_test/model"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type Job struct {
+	Id       string
+	Seq      int64
+	SellerId int64
+	Progress *Progress
+	//адрес, на который отправляется итог задачи, может быть пустым
+	CallbackUrl string
+	//файл в постоянном хранилище, пустой, пока файл не сохранён
+	FilePath  string
+	BatchSize int
+	//листы, выбранные при загрузке, в порядке обработки
+	Sheets []string
+	//время создания задачи, сохраняется в бд вместе с ней. Seq после перезапуска выдаётся заново,
+	//поэтому задачи продавца упорядочиваются по этому времени
+	CreatedAt time.Time
+	//логгер с id задачи и продавца, им пишут все этапы обработки файла
+	Logger *slog.Logger
+
+	mutex      sync.Mutex
+	status     string
+	deliveries []*model.DeliveryAttempt
+	finishOnce sync.Once
+	finishedAt time.Time
+	done       chan struct{}
+	events     *eventLog
+}
+
+func newJob(seq int64, sellerId int64) *Job {
+	events := newEventLog()
+	return &Job{
+		Seq:       seq,
+		SellerId:  sellerId,
+		Progress:  &Progress{events: events},
+		status:    "new",
+		done:      make(chan struct{}),
+		CreatedAt: time.Now(),
+		events:    events,
+		Logger:    slog.Default(),
+	}
+}
+
+func (j *Job) Status() string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.status
+}
+
+// SetStatus игнорируется после завершения задачи, чтобы итоговый статус не затирался
+func (j *Job) SetStatus(status string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.Finished() {
+		return
+	}
+	j.status = status
+	j.events.publish(&model.JobEvent{
+		Type:   "status",
+		Status: status,
+	})
+}
+
+// Finish выставляет итоговый статус, срабатывает только первый вызов
+func (j *Job) Finish(status string) {
+	j.finishOnce.Do(func() {
+		j.mutex.Lock()
+		j.status = status
+		j.finishedAt = time.Now()
+		close(j.done)
+		j.mutex.Unlock()
+		j.events.close(&model.JobEvent{
+			Type:     "finished",
+			Status:   status,
+			Progress: j.Progress.Snapshot(time.Now()),
+		})
+	})
+}
+
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+func (j *Job) Finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Events возвращает события начиная с номера from, канал, закрывающийся при появлении новых,
+// и признак того, что задача завершена и новых событий не будет
+func (j *Job) Events(from int) ([]*model.JobEvent, <-chan struct{}, bool) {
+	return j.events.since(from)
+}
+
+func (j *Job) AddDeliveryAttempt(attempt *model.DeliveryAttempt) {
+	j.mutex.Lock()
+	j.deliveries = append(j.deliveries, attempt)
+	j.mutex.Unlock()
+}
+
+func (j *Job) Deliveries() []*model.DeliveryAttempt {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	deliveries := make([]*model.DeliveryAttempt, len(j.deliveries))
+	copy(deliveries, j.deliveries)
+	return deliveries
+}
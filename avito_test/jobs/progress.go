@@ -0,0 +1,145 @@
+package jobs
+
+import (
+	"avito_test/model"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress накапливает результаты обработки файла, методы безопасны для вызова из воркеров
+type Progress struct {
+	created int64
+	//товары, снятые с продажи, как и deleted в статусе задачи
+	deleted int64
+	events  *eventLog
+
+	mutex         sync.Mutex
+	errorStrings  []string
+	startedAt     time.Time
+	totalRows     int64
+	processedRows int64
+	sheets        []*sheetProgress
+}
+
+type sheetProgress struct {
+	name          string
+	totalRows     int64
+	processedRows int64
+}
+
+// Start отмечает начало обработки строк, от него считаются скорость и оставшееся время
+func (p *Progress) Start(now time.Time) {
+	p.mutex.Lock()
+	p.startedAt = now
+	p.mutex.Unlock()
+}
+
+// AddSheet регистрирует лист и возвращает его номер для AddProcessed
+func (p *Progress) AddSheet(name string, rows int) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.sheets = append(p.sheets, &sheetProgress{
+		name:      name,
+		totalRows: int64(rows),
+	})
+	p.totalRows += int64(rows)
+	return len(p.sheets) - 1
+}
+
+func (p *Progress) AddProcessed(sheet int, rows int) {
+	p.mutex.Lock()
+	p.sheets[sheet].processedRows += int64(rows)
+	p.processedRows += int64(rows)
+	p.mutex.Unlock()
+}
+
+func (p *Progress) AddCreated(count int64) {
+	atomic.AddInt64(&p.created, count)
+	p.events.publish(&model.JobEvent{
+		Type:  "created",
+		Count: count,
+	})
+}
+
+func (p *Progress) AddDeleted(count int64) {
+	atomic.AddInt64(&p.deleted, count)
+	p.events.publish(&model.JobEvent{
+		Type:  "deleted",
+		Count: count,
+	})
+}
+
+func (p *Progress) AddError(errorStr string) {
+	p.mutex.Lock()
+	p.errorStrings = append(p.errorStrings, errorStr)
+	p.mutex.Unlock()
+	p.events.publish(&model.JobEvent{
+		Type:  "error",
+		Error: errorStr,
+	})
+}
+
+func (p *Progress) Created() int64 {
+	return atomic.LoadInt64(&p.created)
+}
+
+func (p *Progress) Deleted() int64 {
+	return atomic.LoadInt64(&p.deleted)
+}
+
+func (p *Progress) ErrorStrings() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	errorStrings := make([]string, len(p.errorStrings))
+	copy(errorStrings, p.errorStrings)
+	return errorStrings
+}
+
+// Snapshot возвращает nil, пока обработка строк не началась
+func (p *Progress) Snapshot(now time.Time) *model.JobProgress {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.startedAt.IsZero() {
+		return nil
+	}
+
+	snapshot := &model.JobProgress{
+		TotalRows:     p.totalRows,
+		ProcessedRows: p.processedRows,
+		Percent:       percent(p.processedRows, p.totalRows),
+		Created:       p.Created(),
+		Deleted:       p.Deleted(),
+		Errors:        len(p.errorStrings),
+		StartedAt:     p.startedAt,
+		Sheets:        []*model.SheetProgress{},
+	}
+	for _, sheet := range p.sheets {
+		snapshot.Sheets = append(snapshot.Sheets, &model.SheetProgress{
+			Name:          sheet.name,
+			TotalRows:     sheet.totalRows,
+			ProcessedRows: sheet.processedRows,
+			Percent:       percent(sheet.processedRows, sheet.totalRows),
+		})
+	}
+
+	elapsed := now.Sub(p.startedAt)
+	if elapsed > 0 && p.processedRows > 0 {
+		rowsPerSecond := float64(p.processedRows) / elapsed.Seconds()
+		snapshot.RowsPerSecond = math.Round(rowsPerSecond*10) / 10
+		if p.processedRows < p.totalRows {
+			left := time.Duration(float64(p.totalRows-p.processedRows) / rowsPerSecond * float64(time.Second))
+			finishAt := now.Add(left)
+			snapshot.EstimatedFinishAt = &finishAt
+		}
+	}
+	return snapshot
+}
+
+func percent(processed int64, total int64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return math.Round(float64(processed)/float64(total)*1000) / 10
+}
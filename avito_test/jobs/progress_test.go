@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressSnapshot(t *testing.T) {
+	p := &Progress{}
+	if p.Snapshot(time.Now()) != nil {
+		t.Errorf("snapshot of not started progress is not nil")
+	}
+
+	startedAt := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	p.Start(startedAt)
+	first := p.AddSheet("first", 300)
+	second := p.AddSheet("second", 100)
+	p.AddProcessed(first, 100)
+	p.AddProcessed(second, 100)
+	p.AddCreated(150)
+	p.AddError("row 1: price lower than zero")
+
+	snapshot := p.Snapshot(startedAt.Add(10 * time.Second))
+	if snapshot.TotalRows != 400 || snapshot.ProcessedRows != 200 || snapshot.Percent != 50 {
+		t.Errorf("got %v of %v rows (%v%%) want 200 of 400 (50%%)",
+			snapshot.ProcessedRows, snapshot.TotalRows, snapshot.Percent)
+	}
+	if snapshot.Created != 150 || snapshot.Errors != 1 {
+		t.Errorf("got created %v, errors %v want 150, 1", snapshot.Created, snapshot.Errors)
+	}
+	if snapshot.RowsPerSecond != 20 {
+		t.Errorf("got %v rows per second want 20", snapshot.RowsPerSecond)
+	}
+	expectedFinish := startedAt.Add(20 * time.Second)
+	if snapshot.EstimatedFinishAt == nil || !snapshot.EstimatedFinishAt.Equal(expectedFinish) {
+		t.Errorf("got estimated finish %v want %v", snapshot.EstimatedFinishAt, expectedFinish)
+	}
+	if len(snapshot.Sheets) != 2 || snapshot.Sheets[0].Percent != 33.3 || snapshot.Sheets[1].Percent != 100 {
+		t.Errorf("unexpected sheets progress: %+v, %+v", snapshot.Sheets[0], snapshot.Sheets[1])
+	}
+
+	p.AddProcessed(first, 200)
+	if snapshot := p.Snapshot(startedAt.Add(20 * time.Second)); snapshot.EstimatedFinishAt != nil {
+		t.Errorf("got estimated finish %v for processed file", snapshot.EstimatedFinishAt)
+	}
+}
@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Registry struct {
+	//сколько завершённая задача остаётся в реестре, 0 - до перезапуска. Статус вытесненной задачи остаётся в бд
+	FinishedTTL time.Duration
+
+	seq   int64
+	mutex sync.RWMutex
+	jobs  map[string]*Job
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		jobs: make(map[string]*Job),
+	}
+}
+
+// Create регистрирует новую задачу, заодно вытесняя давно завершённые, чтобы реестр не рос бесконечно
+func (r *Registry) Create(sellerId int64) *Job {
+	job := newJob(atomic.AddInt64(&r.seq, 1), sellerId)
+	r.EvictFinished(time.Now())
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for {
+		job.Id = uuid.New().String()
+		if _, ok := r.jobs[job.Id]; !ok {
+			break
+		}
+	}
+	r.jobs[job.Id] = job
+	return job
+}
+
+func (r *Registry) Get(id string) (*Job, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	job, ok := r.jobs[id]
+	return job, ok
+}
+
+// GetForSeller не отличает чужую задачу от несуществующей
+func (r *Registry) GetForSeller(id string, sellerId int64) (*Job, bool) {
+	job, ok := r.Get(id)
+	if !ok || job.SellerId != sellerId {
+		return nil, false
+	}
+	return job, true
+}
+
+func (r *Registry) ListBySeller(sellerId int64) []*Job {
+	return r.list(func(job *Job) bool {
+		return job.SellerId == sellerId
+	})
+}
+
+// ListActive возвращает незавершённые задачи всех продавцов
+func (r *Registry) ListActive() []*Job {
+	return r.list(func(job *Job) bool {
+		return !job.Finished()
+	})
+}
+
+// list возвращает задачи, подходящие под filter, в порядке создания
+func (r *Registry) list(filter func(job *Job) bool) []*Job {
+	r.mutex.RLock()
+	jobs := []*Job{}
+	for _, job := range r.jobs {
+		if filter(job) {
+			jobs = append(jobs, job)
+		}
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Seq < jobs[j].Seq
+	})
+	return jobs
+}
+
+// EvictFinished удаляет задачи, завершённые раньше, чем за FinishedTTL до now
+func (r *Registry) EvictFinished(now time.Time) {
+	if r.FinishedTTL <= 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for id, job := range r.jobs {
+		if !job.Finished() {
+			continue
+		}
+		job.mutex.Lock()
+		finishedAt := job.finishedAt
+		job.mutex.Unlock()
+		if now.Sub(finishedAt) > r.FinishedTTL {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+// Restore регистрирует задачу, сохранённую в бд до перезапуска, под её прежним id
+func (r *Registry) Restore(id string, sellerId int64) *Job {
+	job := newJob(atomic.AddInt64(&r.seq, 1), sellerId)
+	job.Id = id
+
+	r.mutex.Lock()
+	r.jobs[id] = job
+	r.mutex.Unlock()
+	return job
+}
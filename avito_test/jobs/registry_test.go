@@ -0,0 +1,99 @@
+package jobs
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentCreate(t *testing.T) {
+	r := NewRegistry()
+
+	const workers, perWorker = 20, 100
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(seller int64) {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				job := r.Create(seller)
+				job.SetStatus("working")
+				job.Progress.AddCreated(1)
+				r.ListBySeller(seller)
+				if _, ok := r.GetForSeller(job.Id, seller); !ok {
+					t.Errorf("job %v is not visible for its seller", job.Id)
+				}
+			}
+		}(int64(i % 4))
+	}
+	wg.Wait()
+
+	ids := map[string]bool{}
+	seqs := map[int64]bool{}
+	for seller := int64(0); seller < 4; seller++ {
+		jobs := r.ListBySeller(seller)
+		for i, job := range jobs {
+			if i > 0 && jobs[i-1].Seq >= job.Seq {
+				t.Errorf("jobs are not ordered by seq: %v after %v", job.Seq, jobs[i-1].Seq)
+			}
+			ids[job.Id] = true
+			seqs[job.Seq] = true
+		}
+	}
+	if len(ids) != workers*perWorker || len(seqs) != workers*perWorker {
+		t.Errorf("got %v ids and %v seqs want %v", len(ids), len(seqs), workers*perWorker)
+	}
+}
+
+func TestGetForAnotherSeller(t *testing.T) {
+	r := NewRegistry()
+	job := r.Create(1)
+
+	if _, ok := r.GetForSeller(job.Id, 2); ok {
+		t.Errorf("job of seller 1 is visible for seller 2")
+	}
+	if _, ok := r.GetForSeller("unknown", 1); ok {
+		t.Errorf("unknown job is visible")
+	}
+}
+
+func TestFinishKeepsFirstStatus(t *testing.T) {
+	job := NewRegistry().Create(1)
+	job.Finish("error: broken file")
+	job.Finish("finished")
+	job.SetStatus("working with sheets")
+
+	if !job.Finished() {
+		t.Errorf("job is not finished")
+	}
+	if job.Status() != "error: broken file" {
+		t.Errorf("got status %v want %v", job.Status(), "error: broken file")
+	}
+}
+
+func TestEvictFinished(t *testing.T) {
+	r := NewRegistry()
+	r.FinishedTTL = time.Hour
+	finished := r.Create(1)
+	finished.Finish("finished")
+	running := r.Create(1)
+
+	r.EvictFinished(time.Now().Add(30 * time.Minute))
+	if _, ok := r.Get(finished.Id); !ok {
+		t.Errorf("job is evicted before ttl")
+	}
+	r.EvictFinished(time.Now().Add(2 * time.Hour))
+	if _, ok := r.Get(finished.Id); ok {
+		t.Errorf("finished job is not evicted after ttl")
+	}
+	if _, ok := r.Get(running.Id); !ok {
+		t.Errorf("running job is evicted")
+	}
+
+	r.FinishedTTL = 0
+	running.Finish("finished")
+	r.EvictFinished(time.Now().Add(24 * time.Hour))
+	if _, ok := r.Get(running.Id); !ok {
+		t.Errorf("job is evicted with zero ttl")
+	}
+}
@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+const RequestIdHeader = "X-Request-Id"
+
+type requestIdKey struct{}
+
+// New создаёт логгер с выводом в json, level - debug, info, warn или error
+func New(w io.Writer, level string) (*slog.Logger, error) {
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %v", level)
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slogLevel})), nil
+}
+
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// FromRequest добавляет к логгеру request id запроса, если он есть
+func FromRequest(logger *slog.Logger, r *http.Request) *slog.Logger {
+	if requestId := RequestId(r.Context()); requestId != "" {
+		return logger.With("request_id", requestId)
+	}
+	return logger
+}
@@ -0,0 +1,12 @@
+package logging
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewRejectsUnknownLevel(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "verbose"); err == nil {
+		t.Errorf("expected error for unknown level")
+	}
+}
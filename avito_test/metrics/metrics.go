@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"database/sql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	Uploads       *prometheus.CounterVec
+	RowsProcessed prometheus.Counter
+	RowsFailed    *prometheus.CounterVec
+	BatchDuration *prometheus.HistogramVec
+	QueryDuration prometheus.Histogram
+}
+
+// New создаёт отдельный реестр, чтобы несколько контроллеров (например, в тестах) не конфликтовали
+func New(activeJobs func() float64) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		Uploads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "avito_uploads_total",
+			Help: "Uploaded price lists by final status.",
+		}, []string{"status"}),
+		RowsProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "avito_rows_processed_total",
+			Help: "Rows of uploaded files processed by import jobs.",
+		}),
+		RowsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "avito_rows_failed_total",
+			Help: "Rows of uploaded files rejected during import by reason.",
+		}, []string{"reason"}),
+		BatchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "avito_batch_duration_seconds",
+			Help:    "Latency of batch upsert and delete statements.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation"}),
+		QueryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "avito_offers_query_duration_seconds",
+			Help:    "Latency of /offers search queries.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.Uploads,
+		m.RowsProcessed,
+		m.RowsFailed,
+		m.BatchDuration,
+		m.QueryDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "avito_active_jobs",
+			Help: "Import jobs currently running.",
+		}, activeJobs),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// RegisterDB добавляет метрики пула соединений с бд, без бд (например, в тестах) их нет
+func (m *Metrics) RegisterDB(db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
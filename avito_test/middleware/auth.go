@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// BasicAuth пропускает только запросы с логином user и паролем password, с пустым user пропускает все
+func BasicAuth(user string, password string) Middleware {
+	return func(next http.Handler) http.Handler {
+		if user == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestUser, requestPassword, ok := r.BasicAuth()
+			if ok &&
+				subtle.ConstantTimeCompare([]byte(requestUser), []byte(user)) == 1 &&
+				subtle.ConstantTimeCompare([]byte(requestPassword), []byte(password)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"avito_test/logging"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+type Middleware func(http.Handler) http.Handler
+
+// Chain оборачивает handler в middlewares, первый из них получает запрос первым
+func Chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// RequestId берёт request id из заголовка или создаёт новый, кладёт его в контекст запроса и в ответ
+func RequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := strings.TrimSpace(r.Header.Get(logging.RequestIdHeader))
+		if requestId == "" || len(requestId) > 128 {
+			requestId = uuid.New().String()
+		}
+		w.Header().Set(logging.RequestIdHeader, requestId)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestId(r.Context(), requestId)))
+	})
+}
+
+// AccessLog пишет по каждому запросу строку access-лога
+func AccessLog(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := &responseWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+
+			logging.FromRequest(logger, r).Info(
+				"request completed",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rw.Status(),
+				"size", rw.size,
+				"duration", time.Since(start).String(),
+			)
+		})
+	}
+}
+
+// Recovery отвечает 500 вместо обрыва соединения, если обработчик запаниковал
+func Recovery(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				//так net/http обрывает ответ намеренно, это не ошибка
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				logging.FromRequest(logger, r).Error("panic in handler", "error", err, "stack", string(debug.Stack()))
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// responseWriter запоминает код и размер ответа для access-лога
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(p)
+	rw.size += n
+	return n, err
+}
+
+func (rw *responseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// Flush нужен для потока событий задачи
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
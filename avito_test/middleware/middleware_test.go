@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"avito_test/logging"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIdAndAccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := logging.New(buf, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromRequest(logger, r).Info("handler called")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok"))
+	}), RequestId, AccessLog(logger))
+
+	for _, incoming := range []string{"", "req-1"} {
+		buf.Reset()
+		req := httptest.NewRequest("GET", "/offers", nil)
+		if incoming != "" {
+			req.Header.Set(logging.RequestIdHeader, incoming)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		requestId := rr.Header().Get(logging.RequestIdHeader)
+		if requestId == "" || (incoming != "" && requestId != incoming) {
+			t.Errorf("got request id %q for incoming %q", requestId, incoming)
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("got %v log lines want 2: %v", len(lines), buf.String())
+		}
+		for _, line := range lines {
+			entry := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry["request_id"] != requestId {
+				t.Errorf("log line without request id: %v", line)
+			}
+		}
+		if !strings.Contains(lines[1], `"status":201`) || !strings.Contains(lines[1], `"size":2`) {
+			t.Errorf("access log without status and size: %v", lines[1])
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("broken handler")
+	}), Recovery(logger))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/offers", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %v want %v", rr.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	called := false
+	handler := CORS([]string{"https://seller.example"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("OPTIONS", "/offers", nil)
+	req.Header.Set("Origin", "https://seller.example")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent || called {
+		t.Errorf("preflight was not answered: status %v, handler called %v", rr.Code, called)
+	}
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://seller.example" {
+		t.Errorf("preflight without allowed origin: %v", rr.Header())
+	}
+
+	req = httptest.NewRequest("GET", "/offers", nil)
+	req.Header.Set("Origin", "https://other.example")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if !called || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("unknown origin is allowed: %v", rr.Header())
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	handler := BasicAuth("admin", "s3cret")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, credentials := range [][2]string{{"", ""}, {"admin", "wrong"}, {"seller", "s3cret"}, {"admin", "s3cret"}} {
+		req := httptest.NewRequest("GET", "/admin/status", nil)
+		if credentials[0] != "" {
+			req.SetBasicAuth(credentials[0], credentials[1])
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		expected := http.StatusUnauthorized
+		if credentials == [2]string{"admin", "s3cret"} {
+			expected = http.StatusOK
+		}
+		if rr.Code != expected {
+			t.Errorf("%v: got status %v want %v", credentials, rr.Code, expected)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// файлы миграций называются <версия>_<название>.up.sql и <версия>_<название>.down.sql
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// lockId - ключ advisory lock, чтобы две копии сервиса не применяли миграции одновременно
+const lockId = 20210213
+
+const createVersionTable = `create table if not exists schema_version (
+version integer primary key,
+name text not null,
+applied_at timestamptz not null default now()
+)`
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load читает встроенные в бинарник миграции, отсортированные по версии
+func Load() ([]*Migration, error) {
+	return load(files)
+}
+
+func load(fsys fs.FS) ([]*Migration, error) {
+	paths, err := fs.Glob(fsys, "sql/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, path := range paths {
+		name := path[len("sql/"):]
+		match := fileName.FindStringSubmatch(name)
+		if match == nil {
+			return nil, fmt.Errorf("incorrect migration file name: %v", name)
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %v has two names: %v and %v", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := []*Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %v_%v must have both up and down files", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must go in a row from 1, got %v at position %v", migration.Version, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator применяет и откатывает миграции, номер последней применённой хранится в schema_version
+type Migrator struct {
+	DB         *sql.DB
+	Logger     *slog.Logger
+	Migrations []*Migration
+}
+
+func New(db *sql.DB, logger *slog.Logger) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		DB:         db,
+		Logger:     logger,
+		Migrations: migrations,
+	}, nil
+}
+
+// Latest возвращает версию, до которой Up доводит схему
+func (m *Migrator) Latest() int {
+	return len(m.Migrations)
+}
+
+// Version возвращает версию схемы в бд, 0 - если миграции ещё не применялись
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, "select to_regclass('schema_version') is not null").Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+	return currentVersion(ctx, m.DB)
+}
+
+// Up применяет все ещё не применённые миграции, каждую в своей транзакции
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.migrate(ctx, m.Latest())
+}
+
+// Down откатывает steps последних применённых миграций
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	target := version - steps
+	if target < 0 {
+		target = 0
+	}
+	return m.migrate(ctx, target)
+}
+
+// migrate по одной миграции доводит схему до версии target в любую сторону
+func (m *Migrator) migrate(ctx context.Context, target int) error {
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("unknown schema version: %v", target)
+	}
+	for {
+		done, err := m.step(ctx, target)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// step применяет или откатывает одну миграцию по направлению к target,
+// версия перечитывается под блокировкой, так как её мог изменить другой процесс. Таблица версий тоже создаётся
+// под блокировкой: два одновременных create table if not exists могут оба не найти таблицу, и один из них упадёт
+// на уникальном индексе каталога postgres
+func (m *Migrator) step(ctx context.Context, target int) (bool, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "select pg_advisory_xact_lock($1)", lockId); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, createVersionTable); err != nil {
+		return false, fmt.Errorf("error in creating schema_version table: %v", err)
+	}
+	version, err := currentVersion(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+	if version > m.Latest() {
+		return false, fmt.Errorf("schema version %v is newer than the latest known migration %v", version, m.Latest())
+	}
+
+	switch {
+	case version < target:
+		migration := m.Migrations[version]
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return false, fmt.Errorf("error in applying migration %v_%v: %v", migration.Version, migration.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, "insert into schema_version (version, name) values ($1, $2)", migration.Version, migration.Name)
+		if err != nil {
+			return false, err
+		}
+		m.Logger.Info("migration applied", "version", migration.Version, "name", migration.Name)
+	case version > target:
+		migration := m.Migrations[version-1]
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return false, fmt.Errorf("error in reverting migration %v_%v: %v", migration.Version, migration.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, "delete from schema_version where version = $1", migration.Version); err != nil {
+			return false, err
+		}
+		m.Logger.Info("migration reverted", "version", migration.Version, "name", migration.Name)
+	default:
+		return true, nil
+	}
+	return false, tx.Commit()
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func currentVersion(ctx context.Context, q queryer) (int, error) {
+	var version int
+	err := q.QueryRowContext(ctx, "select coalesce(max(version), 0) from schema_version").Scan(&version)
+	return version, err
+}
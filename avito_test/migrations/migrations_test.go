@@ -0,0 +1,94 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/lib/pq"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 || migration.Up == "" || migration.Down == "" {
+			t.Errorf("incorrect migration: %+v", migration)
+		}
+	}
+}
+
+func TestLoadRejectsBrokenSets(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("select 1;")}
+	for name, fsys := range map[string]fstest.MapFS{
+		"no down":      {"sql/0001_a.up.sql": file},
+		"gap":          {"sql/0001_a.up.sql": file, "sql/0001_a.down.sql": file, "sql/0003_b.up.sql": file, "sql/0003_b.down.sql": file},
+		"two names":    {"sql/0001_a.up.sql": file, "sql/0001_b.down.sql": file},
+		"wrong suffix": {"sql/0001_a.sql": file},
+	} {
+		if _, err := load(fsys); err == nil {
+			t.Errorf("%v: expected error", name)
+		}
+	}
+}
+
+// TestUpDown работает с настоящей бд, адрес которой задаётся в TEST_DATABASE_DSN,
+// и оставляет схему в последней версии
+func TestUpDown(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m, err := New(db, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	//повторный запуск ничего не делает
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := m.Version(ctx); err != nil || version != m.Latest() {
+		t.Fatalf("got version %v, %v want %v", version, err, m.Latest())
+	}
+
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := m.Version(ctx); err != nil || version != m.Latest()-1 {
+		t.Fatalf("got version %v, %v want %v", version, err, m.Latest()-1)
+	}
+	//несколько копий сервиса стартуют одновременно, миграция применяется один раз
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- m.Up(ctx)
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if version, err := m.Version(ctx); err != nil || version != m.Latest() {
+		t.Fatalf("got version %v, %v want %v", version, err, m.Latest())
+	}
+}
@@ -7,4 +7,3 @@ quantity integer not null,
 available boolean not null,
 constraint product_id primary key(seller_id, offer_id)
 );
-
@@ -0,0 +1,2 @@
+drop table if exists import_batch;
+drop table if exists import_job;
@@ -0,0 +1,20 @@
+create table if not exists import_job (
+id uuid primary key,
+seller_id integer not null,
+file_path text not null,
+callback_url text not null default '',
+batch_size integer not null,
+status text not null,
+finished boolean not null default false,
+created_at timestamptz not null default now()
+);
+
+create table if not exists import_batch (
+job_id uuid not null references import_job(id) on delete cascade,
+sheet integer not null,
+batch integer not null,
+created bigint not null,
+deleted bigint not null,
+errors text[] not null default '{}',
+constraint import_batch_id primary key(job_id, sheet, batch)
+);
@@ -0,0 +1 @@
+alter table import_job drop column if exists sheets;
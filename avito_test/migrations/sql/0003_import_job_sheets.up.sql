@@ -0,0 +1 @@
+alter table import_job add column if not exists sheets text[] not null default '{}';
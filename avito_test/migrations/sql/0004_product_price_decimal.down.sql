@@ -0,0 +1,2 @@
+alter table product drop column if exists currency;
+alter table product alter column price type integer using round(price);
@@ -0,0 +1,2 @@
+alter table product alter column price type numeric(12, 2);
+alter table product add column if not exists currency varchar(3) not null default 'RUB';
@@ -0,0 +1,10 @@
+alter table product drop column if exists height;
+alter table product drop column if exists width;
+alter table product drop column if exists length;
+alter table product drop column if exists weight;
+alter table product drop column if exists images;
+alter table product drop column if exists barcode;
+alter table product drop column if exists brand;
+alter table product drop column if exists category;
+alter table product drop column if exists description;
+alter table product alter column name type varchar(100) using left(name, 100);
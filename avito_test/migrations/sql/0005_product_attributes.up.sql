@@ -0,0 +1,10 @@
+alter table product alter column name type varchar(255);
+alter table product add column if not exists description text not null default '';
+alter table product add column if not exists category text[] not null default '{}';
+alter table product add column if not exists brand varchar(100) not null default '';
+alter table product add column if not exists barcode varchar(14) not null default '';
+alter table product add column if not exists images text[] not null default '{}';
+alter table product add column if not exists weight integer not null default 0;
+alter table product add column if not exists length integer not null default 0;
+alter table product add column if not exists width integer not null default 0;
+alter table product add column if not exists height integer not null default 0;
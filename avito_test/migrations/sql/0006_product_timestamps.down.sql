@@ -0,0 +1,3 @@
+drop index if exists product_updated_at;
+alter table product drop column if exists updated_at;
+alter table product drop column if exists created_at;
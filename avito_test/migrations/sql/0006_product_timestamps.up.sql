@@ -0,0 +1,3 @@
+alter table product add column if not exists created_at timestamptz not null default now();
+alter table product add column if not exists updated_at timestamptz not null default now();
+create index if not exists product_updated_at on product (updated_at);
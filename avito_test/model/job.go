@@ -0,0 +1,61 @@
+package model
+
+import "time"
+
+type Job struct {
+	Id         string
+	Status     string
+	Progress   *JobProgress       `json:",omitempty"`
+	Deliveries []*DeliveryAttempt `json:",omitempty"`
+	//ошибки отдельных предложений в синхронном ответе POST /offers/bulk
+	Errors []string `json:",omitempty"`
+}
+
+// JobProgress - прогресс задачи, Deleted - число товаров, снятых с продажи (они не удаляются, а остаются с available = false)
+type JobProgress struct {
+	TotalRows         int64
+	ProcessedRows     int64
+	Percent           float64
+	Created           int64
+	Deleted           int64
+	Errors            int
+	RowsPerSecond     float64
+	StartedAt         time.Time
+	EstimatedFinishAt *time.Time `json:",omitempty"`
+	Sheets            []*SheetProgress
+}
+
+type SheetProgress struct {
+	Name          string
+	TotalRows     int64
+	ProcessedRows int64
+	Percent       float64
+}
+
+type JobEvent struct {
+	Id       int
+	Time     time.Time
+	Type     string
+	Status   string       `json:",omitempty"`
+	Count    int64        `json:",omitempty"`
+	Error    string       `json:",omitempty"`
+	Progress *JobProgress `json:",omitempty"`
+}
+
+type DeliveryAttempt struct {
+	Attempt    int
+	Time       time.Time
+	StatusCode int    `json:",omitempty"`
+	Error      string `json:",omitempty"`
+}
+
+type JobCallback struct {
+	SellerId int64
+	*Job
+}
+
+// SellerJob - задача вместе с продавцом, для админки
+type SellerJob struct {
+	SellerId int64
+	*Job
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money - сумма в сотых долях валюты (копейках, центах). Хранится целым числом, поэтому не теряет точность,
+// в бд это numeric(12, 2), в json - строка с двумя знаками после точки, например "199.90"
+type Money int64
+
+// MaxMoney - наибольшая сумма, которая помещается в колонку numeric(12, 2)
+const MaxMoney Money = 999999999999
+
+// DefaultCurrency - валюта цены, если она не указана ни в цене, ни в отдельной колонке
+const DefaultCurrency = "RUB"
+
+// ParseMoney разбирает сумму в записи "1299", "1299.9" или "-1299.90": точка отделяет не больше двух знаков
+func ParseMoney(s string) (Money, error) {
+	text := s
+	negative := strings.HasPrefix(text, "-")
+	if negative {
+		text = text[1:]
+	}
+	whole, fraction, _ := strings.Cut(text, ".")
+	if whole == "" || len(fraction) > 2 || len(whole) > 16 || !digits(whole) || !digits(fraction) {
+		return 0, fmt.Errorf("parsing %q: invalid decimal", s)
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	amount, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %q: invalid decimal", s)
+	}
+	if negative {
+		amount = -amount
+	}
+	return Money(amount), nil
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Money) String() string {
+	sign := ""
+	amount := int64(m)
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%v%d.%02d", sign, amount/100, amount%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON принимает и строку, и число, но число не должно быть точнее копеек
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+	amount, err := ParseMoney(text)
+	if err != nil {
+		return err
+	}
+	*m = amount
+	return nil
+}
+
+// Scan читает значение колонки numeric, которое драйвер отдаёт текстом
+func (m *Money) Scan(src interface{}) error {
+	var text string
+	switch value := src.(type) {
+	case []byte:
+		text = string(value)
+	case string:
+		text = value
+	case int64:
+		*m = Money(value * 100)
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into money", src)
+	}
+	amount, err := ParseMoney(text)
+	if err != nil {
+		return err
+	}
+	*m = amount
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
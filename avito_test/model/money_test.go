@@ -0,0 +1,53 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMoneyJSON(t *testing.T) {
+	for amount, expected := range map[Money]string{
+		19990:  `"199.90"`,
+		5:      `"0.05"`,
+		0:      `"0.00"`,
+		-12345: `"-123.45"`,
+	} {
+		data, err := json.Marshal(amount)
+		if err != nil || string(data) != expected {
+			t.Errorf("%d: got %s, %v want %v", amount, data, err, expected)
+		}
+		var decoded Money
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != amount {
+			t.Errorf("%s: got %d, %v want %d", data, decoded, err, amount)
+		}
+	}
+
+	var decoded Money
+	if err := json.Unmarshal([]byte("199.9"), &decoded); err != nil || decoded != 19990 {
+		t.Errorf("number is not accepted: got %d, %v", decoded, err)
+	}
+	for _, data := range []string{`"199.999"`, `199.999`, `"1e3"`, `""`, `"12x"`, `null`} {
+		if err := json.Unmarshal([]byte(data), &decoded); err == nil {
+			t.Errorf("%s: got %d want error", data, decoded)
+		}
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	for src, expected := range map[interface{}]Money{
+		"199.90":         19990,
+		"1299":           129900,
+		int64(7):         700,
+		"-0.01":          -1,
+		"99999999999.99": 9999999999999,
+	} {
+		var amount Money
+		if err := amount.Scan(src); err != nil || amount != expected {
+			t.Errorf("%v: got %d, %v want %d", src, amount, err, expected)
+		}
+	}
+	var amount Money
+	if err := amount.Scan(1.5); err == nil {
+		t.Errorf("float is scanned into %d", amount)
+	}
+}
@@ -1,9 +1,30 @@
 package model
 
+import "time"
+
 type Product struct {
-	SellerId int64
-	OfferId int64
-	Name string
-	Price int
-	Quantity int
+	SellerId int64 `json:"seller_id"`
+	OfferId int64 `json:"offer_id"`
+	Name string `json:"name"`
+	Price Money `json:"price"`
+	//код валюты ISO 4217, например RUB
+	Currency string `json:"currency"`
+	Quantity int `json:"quantity"`
+	//false - товар снят с продажи: он остаётся в хранилище, пока повторная загрузка не сделает его снова доступным
+	Available bool `json:"available"`
+	Description string `json:"description,omitempty"`
+	//путь категории от корня, например [Электроника Телефоны]
+	Category []string `json:"category,omitempty"`
+	Brand string `json:"brand,omitempty"`
+	//штрихкод EAN-8, UPC-A, EAN-13 или GTIN-14
+	Barcode string `json:"barcode,omitempty"`
+	Images []string `json:"images,omitempty"`
+	//вес в граммах и габариты в миллиметрах, 0 - не указаны
+	Weight int `json:"weight,omitempty"`
+	Length int `json:"length,omitempty"`
+	Width int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	//время создания товара и последнего изменения его полей, проставляет хранилище
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -0,0 +1,159 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// ограничения атрибутов товара, совпадают с размерами колонок таблицы product
+const (
+	MaxNameLength        = 255
+	MaxDescriptionLength = 5000
+	MaxCategoryDepth     = 10
+	MaxCategoryLength    = 100
+	MaxBrandLength       = 100
+	MaxImages            = 10
+	MaxImageURLLength    = 2048
+)
+
+// разделители списков в ячейках файла
+const (
+	CategorySeparators = ">/"
+	ImageSeparators    = " \t\r\n,;"
+)
+
+// FieldError - ошибка значения поля товара, Field используется как причина отказа в метриках
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+func fieldError(field string, format string, args ...interface{}) *FieldError {
+	return &FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// ValidateOfferId проверяет offer id и для удаляемого товара: в бд он хранится в integer
+func ValidateOfferId(offerId int64) *FieldError {
+	if offerId <= 0 {
+		return fieldError("offer_id", "offer id lower or equals zero")
+	}
+	if offerId > math.MaxInt32 {
+		return fieldError("offer_id", "offer id is too large")
+	}
+	return nil
+}
+
+// Validate проверяет значения полей доступного товара независимо от того, из какого формата он разобран
+func (p *Product) Validate() *FieldError {
+	if err := ValidateOfferId(p.OfferId); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(p.Name) > MaxNameLength {
+		return fieldError("name", "name is longer than %v characters", MaxNameLength)
+	}
+	if p.Price < 0 {
+		return fieldError("price", "price lower than zero")
+	}
+	if p.Price > MaxMoney {
+		return fieldError("price", "price is too large")
+	}
+	if p.Currency != "" && !IsCurrencyCode(p.Currency) {
+		return fieldError("currency", "currency %q is not an ISO 4217 code", p.Currency)
+	}
+	if p.Quantity < 0 {
+		return fieldError("quantity", "quantity lower than zero")
+	}
+	if p.Quantity > math.MaxInt32 {
+		return fieldError("quantity", "quantity is too large")
+	}
+
+	if utf8.RuneCountInString(p.Description) > MaxDescriptionLength {
+		return fieldError("description", "description is longer than %v characters", MaxDescriptionLength)
+	}
+	if len(p.Category) > MaxCategoryDepth {
+		return fieldError("category", "category is deeper than %v levels", MaxCategoryDepth)
+	}
+	for _, category := range p.Category {
+		if category == "" || utf8.RuneCountInString(category) > MaxCategoryLength {
+			return fieldError("category", "category level must be from 1 to %v characters", MaxCategoryLength)
+		}
+		//в файле уровни разделяются этими символами, внутри уровня они не пережили бы выгрузку и загрузку обратно
+		if strings.ContainsAny(category, CategorySeparators) {
+			return fieldError("category", "category level %q must not contain > or /", category)
+		}
+	}
+	if utf8.RuneCountInString(p.Brand) > MaxBrandLength {
+		return fieldError("brand", "brand is longer than %v characters", MaxBrandLength)
+	}
+	if p.Barcode != "" && !ValidBarcode(p.Barcode) {
+		return fieldError("barcode", "barcode %q is not a valid EAN-8, UPC-A, EAN-13 or GTIN-14", p.Barcode)
+	}
+	if len(p.Images) > MaxImages {
+		return fieldError("images", "more than %v images", MaxImages)
+	}
+	for _, image := range p.Images {
+		if len(image) > MaxImageURLLength || !validURL(image) {
+			return fieldError("images", "image %q is not an http or https url", image)
+		}
+		if strings.ContainsAny(image, ImageSeparators) {
+			return fieldError("images", "image %q must not contain spaces, commas or semicolons", image)
+		}
+	}
+	for _, size := range []struct {
+		field string
+		value int
+	}{{"weight", p.Weight}, {"length", p.Length}, {"width", p.Width}, {"height", p.Height}} {
+		if size.value < 0 || size.value > math.MaxInt32 {
+			return fieldError(size.field, "%v is out of range", size.field)
+		}
+	}
+	return nil
+}
+
+// IsCurrencyCode проверяет, что код похож на код валюты ISO 4217: три заглавные латинские буквы
+func IsCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidBarcode проверяет длину и контрольную цифру штрихкода GTIN: EAN-8, UPC-A, EAN-13 или GTIN-14
+func ValidBarcode(barcode string) bool {
+	switch len(barcode) {
+	case 8, 12, 13, 14:
+	default:
+		return false
+	}
+	sum := 0
+	for i := len(barcode) - 2; i >= 0; i-- {
+		digit := int(barcode[i] - '0')
+		if digit < 0 || digit > 9 {
+			return false
+		}
+		//цифры справа налево, начиная с ближайшей к контрольной, умножаются на 3, 1, 3, 1...
+		if (len(barcode)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	check := int(barcode[len(barcode)-1] - '0')
+	return check >= 0 && check <= 9 && (10-sum%10)%10 == check
+}
+
+func validURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
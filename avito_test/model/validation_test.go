@@ -0,0 +1,84 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidBarcode(t *testing.T) {
+	for barcode, expected := range map[string]bool{
+		"4006381333931":  true,
+		"4600682000419":  true,
+		"96385074":       true,
+		"036000291452":   true,
+		"10012345678902": true,
+		"4006381333932":  false,
+		"400638133393":   false,
+		"40063813339a1":  false,
+		"":               false,
+	} {
+		if ValidBarcode(barcode) != expected {
+			t.Errorf("%q: got %v want %v", barcode, !expected, expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Product {
+		return &Product{
+			OfferId:  1,
+			Name:     "Груша",
+			Price:    19990,
+			Currency: "RUB",
+			Quantity: 1,
+			Category: []string{"Фрукты", "Груши"},
+			Barcode:  "4006381333931",
+			Images:   []string{"https://img.example.com/pear.jpg"},
+			Weight:   180,
+		}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid product is rejected: %v", err)
+	}
+
+	for field, change := range map[string]func(p *Product){
+		"offer_id":    func(p *Product) { p.OfferId = 0 },
+		"name":        func(p *Product) { p.Name = strings.Repeat("я", MaxNameLength+1) },
+		"price":       func(p *Product) { p.Price = -1 },
+		"currency":    func(p *Product) { p.Currency = "rub" },
+		"quantity":    func(p *Product) { p.Quantity = -1 },
+		"description": func(p *Product) { p.Description = strings.Repeat("a", MaxDescriptionLength+1) },
+		"category":    func(p *Product) { p.Category = []string{"Фрукты", ""} },
+		"brand":       func(p *Product) { p.Brand = strings.Repeat("b", MaxBrandLength+1) },
+		"barcode":     func(p *Product) { p.Barcode = "4006381333932" },
+		"images":      func(p *Product) { p.Images = []string{"ftp://img.example.com/pear.jpg"} },
+		"height":      func(p *Product) { p.Height = -5 },
+	} {
+		product := valid()
+		change(product)
+		if err := product.Validate(); err == nil || err.Field != field {
+			t.Errorf("%v: got error %v", field, err)
+		}
+	}
+
+	//разделители списков внутри значения не пережили бы выгрузку и загрузку обратно
+	for field, change := range map[string]func(p *Product){
+		"category": func(p *Product) { p.Category = []string{"Фрукты / Груши"} },
+		"images": func(p *Product) {
+			p.Images = []string{"https://img.example.com/pear.jpg,https://img.example.com/2.jpg"}
+		},
+	} {
+		product := valid()
+		change(product)
+		if err := product.Validate(); err == nil || err.Field != field {
+			t.Errorf("%v with separator: got error %v", field, err)
+		}
+	}
+
+	//offer id в бд - integer
+	product := valid()
+	product.OfferId = 1 << 31
+	if err := product.Validate(); err == nil || err.Field != "offer_id" {
+		t.Errorf("offer id out of int32: got error %v", err)
+	}
+}
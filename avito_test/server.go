@@ -1,53 +1,247 @@
 package main
 
 import (
+	"avito_test/config"
 	"avito_test/controller"
+	"avito_test/logging"
+	"avito_test/middleware"
+	"avito_test/migrations"
+	"avito_test/storage"
+	"context"
 	"database/sql"
+	"flag"
 	"fmt"
-	"github.com/go-martini/martini"
 	_ "github.com/lib/pq"
+	"log"
+	"log/slog"
+	"net/http"
 	"net/http/pprof"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
-const DSN = "user=root password=root dbname=root sslmode=disable"
+func newServer(store storage.Storage, cfg *config.Config, logger *slog.Logger) (http.Handler, *controller.Controller) {
+	c := controller.NewController(store, cfg, logger)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", c.Healthz)
+	mux.HandleFunc("GET /readyz", c.Readyz)
+	mux.HandleFunc("GET /proc", c.GetProcStatus)
+	mux.HandleFunc("GET /proc/{id}/events", c.StreamProcEvents)
+	mux.HandleFunc("GET /jobs", c.ListJobs)
+	mux.HandleFunc("GET /offers", c.FindOffersByParams)
+	mux.HandleFunc("GET /offers/export", c.ExportOffers)
+	mux.HandleFunc("POST /offers/bulk", c.ReceiveOffersBulk)
+	mux.HandleFunc("POST /send", c.ReadFileFromRequest)
+	//без отдельного адреса админка доступна на основном порту, но только с логином и паролем
+	if cfg.Admin.Addr == "" && cfg.Admin.User != "" {
+		admin := adminHandler(c, cfg.Admin)
+		mux.Handle("/admin/", admin)
+		mux.Handle("/debug/pprof/", admin)
+		mux.Handle("/metrics", admin)
+	}
+	return withMiddlewares(mux, logger, middleware.CORS(cfg.CORSOrigins)), c
+}
+
+// newAdminServer отдаёт pprof, метрики и состояние сервиса на отдельном адресе, не видном продавцам
+func newAdminServer(c *controller.Controller, logger *slog.Logger) http.Handler {
+	return withMiddlewares(adminHandler(c, c.Config.Admin), logger)
+}
+
+func adminHandler(c *controller.Controller, admin config.Admin) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/status", c.AdminStatus)
+	mux.Handle("GET /metrics", c.Metrics.Handler())
+	//heap, goroutine и остальные профили pprof.Index отдаёт по имени из пути
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return middleware.BasicAuth(admin.User, admin.Password)(mux)
+}
+
+// newHTTPServer задаёт таймауты, без которых медленный клиент может бесконечно держать соединение
+func newHTTPServer(addr string, handler http.Handler, cfg *config.Config) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// withMiddlewares добавляет общие для всех адресов middleware: request id нужен логу и recovery,
+// а recovery стоит внутри лога, чтобы в access-лог попал ответ 500
+func withMiddlewares(handler http.Handler, logger *slog.Logger, extra ...middleware.Middleware) http.Handler {
+	middlewares := []middleware.Middleware{
+		middleware.RequestId,
+		middleware.AccessLog(logger),
+		middleware.Recovery(logger),
+	}
+	return middleware.Chain(handler, append(middlewares, extra...)...)
+}
+
+// connectDB повторяет попытки подключения с растущей паузой, пока не истечёт ConnectTimeout,
+// так как при старте через docker-compose бд может быть ещё не готова
+func connectDB(dbConfig config.Database, logger *slog.Logger) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dbConfig.DSN())
+	if err != nil {
+		return nil, err
+	}
 
-func newServer(db *sql.DB) *martini.ClassicMartini {
-	c := controller.NewController(db)
-	go c.ListenControllerChans()
-	m := martini.Classic()
-	m.Get("/proc", c.GetProcStatus)
-	m.Get("/offers", c.FindOffersByParams)
-	m.Post("/send", c.ReadFileFromRequest)
-	m.Any("/debug/pprof/", pprof.Index)
-	m.Any("/debug/pprof/cmdline", pprof.Cmdline)
-	m.Any("/debug/pprof/profile", pprof.Profile)
-	m.Any("/debug/pprof/symbol", pprof.Symbol)
-	m.Any("/debug/pprof/trace", pprof.Trace)
-	return m
+	deadline := time.Now().Add(dbConfig.ConnectTimeout)
+	delay := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			db.Close()
+			return nil, fmt.Errorf("db is unreachable after %v attempts: %v", attempt, err)
+		}
+		logger.Warn("db is unreachable, retrying", "attempt", attempt, "delay", delay.String(), "error", err)
+		time.Sleep(delay)
+		if delay *= 2; delay > 10*time.Second {
+			delay = 10 * time.Second
+		}
+	}
+}
+
+// runMigrate выполняет команду migrate: up, down [количество] или version
+func runMigrate(db *sql.DB, logger *slog.Logger, args []string) error {
+	migrator, err := migrations.New(db, logger)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up | down [steps] | version")
+	}
+
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("steps must be a positive number: %v", args[1])
+			}
+		}
+		return migrator.Down(ctx, steps)
+	case "version":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %v, latest %v\n", version, migrator.Latest())
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command: %v", args[0])
+	}
 }
 
 func main() {
-	/*name := os.Getenv("DATABASE_NAME")
-	user := os.Getenv("DATABASE_USER")
-	pass := os.Getenv("DATABASE_PASS")
-	host := os.Getenv("DATABASE_HOST")
-	log.Println(name, user, pass)
-	dsn := fmt.Sprintf(
-		"host=%v port=5432 user=%v dbname=%v password=%v sslmode=disable",
-		host,
-		user,
-		name,
-		pass,
-	)*/
-
-	db, err := sql.Open("postgres", DSN)
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to yaml config file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %v [flags] [migrate up | down [steps] | version]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		panic(err)
+		log.Fatalln("error in loading config:", err)
+	}
+	logger, err := logging.New(os.Stdout, cfg.LogLevel)
+	if err != nil {
+		log.Fatalln("error in creating logger:", err)
+	}
+	logger.Info("effective config", "config", cfg.String())
+	if err := os.MkdirAll(cfg.StorageDir, 0755); err != nil {
+		logger.Error("error in creating storage dir", "error", err)
+		os.Exit(1)
+	}
+
+	db, err := connectDB(cfg.Database, logger)
+	if err != nil {
+		logger.Error("error in connecting to db", "error", err)
+		os.Exit(1)
 	}
-	db.Ping()
 	defer db.Close()
-	fmt.Println("Connected to db")
+	logger.Info("connected to db")
 
-	m := newServer(db)
-	m.RunOnAddr(":8080")
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(db, logger, flag.Args()[1:]); err != nil {
+			logger.Error("error in migrating db", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if cfg.MigrateOnStart {
+		if err := runMigrate(db, logger, []string{"up"}); err != nil {
+			logger.Error("error in migrating db", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	store := storage.NewPostgres(db)
+	handler, c := newServer(store, cfg, logger)
+	c.Metrics.RegisterDB(db)
+	store.OnBatchQuery = func(operation string, duration time.Duration) {
+		c.Metrics.BatchDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	}
+	if err := c.ResumeJobs(); err != nil {
+		logger.Error("error in resuming unfinished jobs", "error", err)
+	}
+	srv := newHTTPServer(cfg.Addr, handler, cfg)
+	go func() {
+		logger.Info("listening", "addr", cfg.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("error in serving http", "error", err)
+			os.Exit(1)
+		}
+	}()
+	var adminSrv *http.Server
+	if cfg.Admin.Addr != "" {
+		adminSrv = newHTTPServer(cfg.Admin.Addr, newAdminServer(c, logger), cfg)
+		go func() {
+			logger.Info("admin listening", "addr", cfg.Admin.Addr)
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("error in serving admin http", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	logger.Info("shutting down, waiting for running jobs")
+
+	//пока задачи дорабатывают, статус по ним по-прежнему можно получить, новые загрузки отклоняются
+	c.Shutdown(cfg.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("error in shutting down http server", "error", err)
+	}
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			logger.Error("error in shutting down admin http server", "error", err)
+		}
+	}
+	logger.Info("server stopped")
 }
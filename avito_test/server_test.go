@@ -1,42 +1,82 @@
 package main
 
 import (
+	"avito_test/config"
 	"avito_test/controller"
+	"avito_test/fixtures"
+	"avito_test/jobs"
+	"avito_test/logging"
+	"avito_test/model"
+	"avito_test/storage"
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-func initDbForTests() *sql.DB {
-	dsn := "user=root password=root dbname=root sslmode=disable"
+var testLogger = slog.New(slog.NewJSONHandler(io.Discard, nil))
 
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		panic(err)
+// testNow - время по часам хранилища тестового сервера, testTimestamps - оно же в json товара
+var testNow = time.Date(2021, 2, 13, 12, 0, 0, 0, time.UTC)
+
+const testTimestamps = `"created_at":"2021-02-13T12:00:00Z","updated_at":"2021-02-13T12:00:00Z"`
+
+// newTestServer собирает настоящий роутер со всеми middleware поверх хранилища в памяти
+func newTestServer(t *testing.T, cfg *config.Config) (http.Handler, *controller.Controller) {
+	store := storage.NewMemory()
+	store.Now = func() time.Time {
+		return testNow
+	}
+	return newServer(store, cfg, testLogger)
+}
+
+// seedProducts сохраняет товары так же, как это делает импорт файла
+func seedProducts(t *testing.T, store storage.Storage, products ...*model.Product) {
+	ctx := context.Background()
+	for i, product := range products {
+		jobId := fmt.Sprintf("seed-%v-%v", product.SellerId, product.OfferId)
+		if err := store.InsertJob(ctx, &storage.StoredJob{Id: jobId, SellerId: product.SellerId}, "seed"); err != nil {
+			t.Fatal(err)
+		}
+		batch := &storage.Batch{SellerId: product.SellerId, Upsert: []*model.Product{product}}
+		if err := store.SaveBatch(ctx, jobId, jobs.BatchKey{Batch: i}, batch, &jobs.Checkpoint{}); err != nil {
+			t.Fatal(err)
+		}
 	}
-	db.Ping()
+}
 
-	return db
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
 }
 
 func TestIncorrectProcNumber(t *testing.T) {
-	c := controller.NewController(initDbForTests())
-	defer c.DB.Close()
+	m, _ := newTestServer(t, config.Default())
 
-	req, err := http.NewRequest("GET", "/proc?number=0", nil)
+	req, err := http.NewRequest("GET", "/proc?seller=0&id=0", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	rr := httptest.NewRecorder()
-	getProcStatus := func(w http.ResponseWriter, r *http.Request) {
-		code, response := c.GetProcStatus(r)
-		w.WriteHeader(code)
-		w.Write([]byte(response))
-	}
-	handler := http.HandlerFunc(getProcStatus)
-	handler.ServeHTTP(rr, req)
+	rr := serve(m, req)
 
 	if status := rr.Code; status != http.StatusInternalServerError {
 		t.Errorf("handler returned wrong status code: got %v want %v",
@@ -51,168 +91,280 @@ func TestIncorrectProcNumber(t *testing.T) {
 }
 
 func TestCorrectProcNumber(t *testing.T) {
-	c := controller.NewController(initDbForTests())
-	defer c.DB.Close()
-	c.Goroutine2Status[0] = "new"
+	m, c := newTestServer(t, config.Default())
+	jobId := c.Jobs.Create(0).Id
 
-	req, err := http.NewRequest("GET", "/proc?number=0", nil)
+	req, err := http.NewRequest("GET", "/proc?seller=0&id="+jobId, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	rr := httptest.NewRecorder()
-	getProcStatus := func(w http.ResponseWriter, r *http.Request) {
-		code, response := c.GetProcStatus(r)
-		w.WriteHeader(code)
-		w.Write([]byte(response))
-	}
-	handler := http.HandlerFunc(getProcStatus)
-	handler.ServeHTTP(rr, req)
+	rr := serve(m, req)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusOK)
 	}
 
-	expected := `new`
+	expected := `{"Id":"` + jobId + `","Status":"new"}`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
 	}
 }
 
-func TestFindProduct(t *testing.T) {
-	c := controller.NewController(initDbForTests())
-	_, err := c.DB.Exec(
-		"insert into product (seller_id, offer_id, name, price, quantity, available) values (0, 0, 'test', 1000, 1000, true);")
+func TestProcNumberOfAnotherSeller(t *testing.T) {
+	m, c := newTestServer(t, config.Default())
+	jobId := c.Jobs.Create(0).Id
+
+	req, err := http.NewRequest("GET", "/proc?seller=1&id="+jobId, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	req, err := http.NewRequest("GET", "/offers?seller=0&offer=0&name=es", nil)
+	rr := serve(m, req)
+
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+
+	expected := `incorrect procedure number`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestListJobsBySeller(t *testing.T) {
+	m, c := newTestServer(t, config.Default())
+	jobId := c.Jobs.Create(0).Id
+	c.Jobs.Create(1)
+
+	req, err := http.NewRequest("GET", "/jobs?seller=0", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	rr := httptest.NewRecorder()
-	getOffers := func(w http.ResponseWriter, r *http.Request) {
-		code, response := c.FindOffersByParams(w, r)
-		w.WriteHeader(code)
-		w.Write([]byte(response))
-	}
-	handler := http.HandlerFunc(getOffers)
-	handler.ServeHTTP(rr, req)
+	rr := serve(m, req)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusOK)
 	}
 
-	expected := `[{"SellerId":0,"OfferId":0,"Name":"test","Price":1000,"Quantity":1000}]`
+	expected := `[{"Id":"` + jobId + `","Status":"new"}]`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
 	}
-	_, err = c.DB.Exec("delete from product where seller_id = 0 and offer_id = 0")
-	if err != nil {
+}
+
+// TestStoredJobStatus проверяет задачу, которой нет в памяти (принятую до перезапуска или давно завершённую):
+// её статус берётся из хранилища
+func TestStoredJobStatus(t *testing.T) {
+	m, c := newTestServer(t, config.Default())
+	ctx := context.Background()
+	if err := c.Store.InsertJob(ctx, &storage.StoredJob{Id: "stored-job", SellerId: 3}, "file prepared for using"); err != nil {
 		t.Fatal(err)
 	}
+	if err := c.Store.FinishJob(ctx, "stored-job", "finished"); err != nil {
+		t.Fatal(err)
+	}
+	jobId := c.Jobs.Create(3).Id
+
+	if rr := serve(m, httptest.NewRequest("GET", "/proc?seller=3&id=stored-job", nil)); rr.Code != http.StatusOK || rr.Body.String() != `{"Id":"stored-job","Status":"finished"}` {
+		t.Errorf("got %v, %v", rr.Code, rr.Body.String())
+	}
+	if rr := serve(m, httptest.NewRequest("GET", "/proc?seller=4&id=stored-job", nil)); rr.Code != http.StatusInternalServerError || rr.Body.String() != "incorrect procedure number" {
+		t.Errorf("job of another seller: got %v, %v", rr.Code, rr.Body.String())
+	}
+	expected := `[{"Id":"stored-job","Status":"finished"},{"Id":"` + jobId + `","Status":"new"}]`
+	if rr := serve(m, httptest.NewRequest("GET", "/jobs?seller=3", nil)); rr.Code != http.StatusOK || rr.Body.String() != expected {
+		t.Errorf("got %v, %v want %v", rr.Code, rr.Body.String(), expected)
+	}
 }
 
-func TestFindNonExistentNameProduct(t *testing.T) {
-	c := controller.NewController(initDbForTests())
-	_, err := c.DB.Exec(
-		"insert into product (seller_id, offer_id, name, price, quantity, available) values (0, 0, 'test', 1000, 1000, true);")
-	if err != nil {
+// TestListJobsAfterRestart проверяет, что восстановленная после перезапуска задача стоит в списке на месте
+// своего создания, а не после задач, которых уже нет в памяти
+func TestListJobsAfterRestart(t *testing.T) {
+	cfg := config.Default()
+	cfg.TempDir = t.TempDir()
+	cfg.StorageDir = t.TempDir()
+	store := storage.NewMemory()
+	ctx := context.Background()
+	createdAt := time.Now().Add(-time.Hour)
+
+	filePath := filepath.Join(cfg.StorageDir, "resumed.xlsx")
+	if err := ioutil.WriteFile(filePath, buildFixture(t, fixtures.Sheet{Rows: fixtures.Offers(1, 3)}), 0644); err != nil {
 		t.Fatal(err)
 	}
+	for i, stored := range []*storage.StoredJob{
+		{Id: "finished-before", SellerId: 1},
+		{Id: "resumed", SellerId: 1, FilePath: filePath, BatchSize: cfg.BatchSize},
+		{Id: "finished-after", SellerId: 1},
+	} {
+		stored.CreatedAt = createdAt.Add(time.Duration(i) * time.Minute)
+		if err := store.InsertJob(ctx, stored, "working with rows"); err != nil {
+			t.Fatal(err)
+		}
+		if stored.FilePath == "" {
+			if err := store.FinishJob(ctx, stored.Id, "finished"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	m, c := newServer(store, cfg, testLogger)
+	if err := c.ResumeJobs(); err != nil {
+		t.Fatal(err)
+	}
+	resumed, ok := c.Jobs.Get("resumed")
+	if !ok {
+		t.Fatal("job is not resumed")
+	}
+	<-resumed.Done()
+	uploaded := runImport(t, m, c, 1, fixtures.Sheet{Rows: fixtures.Offers(10, 1)})
 
-	req, err := http.NewRequest("GET", "/offers?seller=0&offer=0&name=no", nil)
+	rr := serve(m, httptest.NewRequest("GET", "/jobs?seller=1", nil))
+	listed := []*model.Job{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("got %v, %v: %v", rr.Code, rr.Body.String(), err)
+	}
+	ids := []string{}
+	for _, job := range listed {
+		ids = append(ids, job.Id)
+	}
+	expected := []string{"finished-before", "resumed", "finished-after", uploaded.Id}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("got jobs %q want %q", ids, expected)
+	}
+}
+
+func TestFindProduct(t *testing.T) {
+	m, c := newTestServer(t, config.Default())
+	seedProducts(t, c.Store, &model.Product{SellerId: 0, OfferId: 0, Name: "test", Price: 100000, Currency: "RUB", Quantity: 1000})
+
+	req, err := http.NewRequest("GET", "/offers?seller=0&offer=0&name=es", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	rr := httptest.NewRecorder()
-	getOffers := func(w http.ResponseWriter, r *http.Request) {
-		code, response := c.FindOffersByParams(w, r)
-		w.WriteHeader(code)
-		w.Write([]byte(response))
-	}
-	handler := http.HandlerFunc(getOffers)
-	handler.ServeHTTP(rr, req)
+	rr := serve(m, req)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusOK)
 	}
 
-	expected := `[]`
+	expected := `[{"seller_id":0,"offer_id":0,"name":"test","price":"1000.00","currency":"RUB","quantity":1000,"available":true,` + testTimestamps + `}]`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
 	}
-	_, err = c.DB.Exec("delete from product where seller_id = 0 and offer_id = 0")
+}
+
+func TestFindNonExistentNameProduct(t *testing.T) {
+	m, c := newTestServer(t, config.Default())
+	seedProducts(t, c.Store, &model.Product{SellerId: 0, OfferId: 0, Name: "test", Price: 100000, Currency: "RUB", Quantity: 1000})
+
+	req, err := http.NewRequest("GET", "/offers?seller=0&offer=0&name=no", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	rr := serve(m, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `[]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
 }
 
 func TestFindProductsBySeller(t *testing.T) {
-	c := controller.NewController(initDbForTests())
-	_, err := c.DB.Exec(
-		"insert into product (seller_id, offer_id, name, price, quantity, available) values (0, 0, 'test', 1000, 1000, true), (0, 1, 'test', 1000, 1000, true), (0, 2, 'test', 1000, 1000, true);")
-	if err != nil {
-		t.Fatal(err)
-	}
+	m, c := newTestServer(t, config.Default())
+	seedProducts(t, c.Store,
+		&model.Product{SellerId: 0, OfferId: 0, Name: "test", Price: 100000, Currency: "RUB", Quantity: 1000},
+		&model.Product{SellerId: 0, OfferId: 1, Name: "test", Price: 100000, Currency: "RUB", Quantity: 1000},
+		&model.Product{SellerId: 0, OfferId: 2, Name: "test", Price: 100000, Currency: "RUB", Quantity: 1000},
+		&model.Product{SellerId: 1, OfferId: 0, Name: "test", Price: 100000, Currency: "RUB", Quantity: 1000},
+	)
 
 	req, err := http.NewRequest("GET", "/offers?seller=0", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	rr := httptest.NewRecorder()
-	getOffers := func(w http.ResponseWriter, r *http.Request) {
-		code, response := c.FindOffersByParams(w, r)
-		w.WriteHeader(code)
-		w.Write([]byte(response))
-	}
-	handler := http.HandlerFunc(getOffers)
-	handler.ServeHTTP(rr, req)
+	rr := serve(m, req)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusOK)
 	}
 
-	expected := `[{"SellerId":0,"OfferId":0,"Name":"test","Price":1000,"Quantity":1000},{"SellerId":0,"OfferId":1,"Name":"test","Price":1000,"Quantity":1000},{"SellerId":0,"OfferId":2,"Name":"test","Price":1000,"Quantity":1000}]`
+	expected := `[{"seller_id":0,"offer_id":0,"name":"test","price":"1000.00","currency":"RUB","quantity":1000,"available":true,` + testTimestamps + `},{"seller_id":0,"offer_id":1,"name":"test","price":"1000.00","currency":"RUB","quantity":1000,"available":true,` + testTimestamps + `},{"seller_id":0,"offer_id":2,"name":"test","price":"1000.00","currency":"RUB","quantity":1000,"available":true,` + testTimestamps + `}]`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
 	}
-	_, err = c.DB.Exec("delete from product where seller_id = 0 and offer_id in (0, 1, 2);")
+}
+
+func TestFindProductsUpdatedSince(t *testing.T) {
+	m, c := newTestServer(t, config.Default())
+	seedProducts(t, c.Store, &model.Product{SellerId: 0, OfferId: 0, Name: "old", Price: 100, Currency: "RUB", Quantity: 1})
+	c.Store.(*storage.Memory).Now = func() time.Time {
+		return testNow.Add(time.Hour)
+	}
+	seedProducts(t, c.Store, &model.Product{SellerId: 1, OfferId: 1, Name: "new", Price: 100, Currency: "RUB", Quantity: 1})
+
+	for query, expected := range map[string]string{
+		"updated_since=2021-02-13T12:30:00Z":          `[{"seller_id":1,"offer_id":1,"name":"new","price":"1.00","currency":"RUB","quantity":1,"available":true,"created_at":"2021-02-13T13:00:00Z","updated_at":"2021-02-13T13:00:00Z"}]`,
+		"updated_since=2021-02-13T15:00:00%2B03:00":   `[{"seller_id":0,"offer_id":0,"name":"old","price":"1.00","currency":"RUB","quantity":1,"available":true,` + testTimestamps + `},{"seller_id":1,"offer_id":1,"name":"new","price":"1.00","currency":"RUB","quantity":1,"available":true,"created_at":"2021-02-13T13:00:00Z","updated_at":"2021-02-13T13:00:00Z"}]`,
+		"seller=0&updated_since=2021-02-13T12:00:01Z": `[]`,
+	} {
+		rr := serve(m, httptest.NewRequest("GET", "/offers?"+query, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != expected {
+			t.Errorf("%v: got %v, %v want %v", query, rr.Code, rr.Body.String(), expected)
+		}
+	}
+
+	//снятый с продажи товар виден синхронизации, но не обычному поиску
+	err := c.Store.SaveBatch(context.Background(), "seed-1-1", jobs.BatchKey{Batch: 1}, &storage.Batch{SellerId: 1, Unavailable: []int64{1}}, &jobs.Checkpoint{})
 	if err != nil {
 		t.Fatal(err)
 	}
+	unavailable := `[{"seller_id":1,"offer_id":1,"name":"new","price":"1.00","currency":"RUB","quantity":1,"available":false,"created_at":"2021-02-13T13:00:00Z","updated_at":"2021-02-13T13:00:00Z"}]`
+	for query, expected := range map[string]string{
+		"updated_since=2021-02-13T12:30:00Z": unavailable,
+		"seller=1":                           `[]`,
+	} {
+		rr := serve(m, httptest.NewRequest("GET", "/offers?"+query, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != expected {
+			t.Errorf("%v: got %v, %v want %v", query, rr.Code, rr.Body.String(), expected)
+		}
+	}
+
+	rr := serve(m, httptest.NewRequest("GET", "/offers?updated_since=yesterday", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("incorrect updated_since: got %v, %v", rr.Code, rr.Body.String())
+	}
 }
 
 func TestIncorrectSellerNumber(t *testing.T) {
-	c := controller.NewController(initDbForTests())
-	defer c.DB.Close()
-	c.Goroutine2Status[0] = "new"
+	m, _ := newTestServer(t, config.Default())
 
-	req, err := http.NewRequest("Post", "/send?seller=test", nil)
+	req, err := http.NewRequest("POST", "/send?seller=test", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	rr := httptest.NewRecorder()
-	sendFile := func(w http.ResponseWriter, r *http.Request) {
-		code, response := c.ReadFileFromRequest(r)
-		w.WriteHeader(code)
-		w.Write([]byte(response))
-	}
-	handler := http.HandlerFunc(sendFile)
-	handler.ServeHTTP(rr, req)
+	rr := serve(m, req)
 
 	if status := rr.Code; status != http.StatusInternalServerError {
 		t.Errorf("handler returned wrong status code: got %v want %v",
@@ -227,23 +379,14 @@ func TestIncorrectSellerNumber(t *testing.T) {
 }
 
 func TestEmptyBody(t *testing.T) {
-	c := controller.NewController(initDbForTests())
-	defer c.DB.Close()
-	c.Goroutine2Status[0] = "new"
+	m, _ := newTestServer(t, config.Default())
 
-	req, err := http.NewRequest("Post", "/send?seller=0", nil)
+	req, err := http.NewRequest("POST", "/send?seller=0", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	rr := httptest.NewRecorder()
-	sendFile := func(w http.ResponseWriter, r *http.Request) {
-		code, response := c.ReadFileFromRequest(r)
-		w.WriteHeader(code)
-		w.Write([]byte(response))
-	}
-	handler := http.HandlerFunc(sendFile)
-	handler.ServeHTTP(rr, req)
+	rr := serve(m, req)
 
 	if status := rr.Code; status != http.StatusInternalServerError {
 		t.Errorf("handler returned wrong status code: got %v want %v",
@@ -256,3 +399,587 @@ func TestEmptyBody(t *testing.T) {
 			rr.Body.String(), expected)
 	}
 }
+
+func newUploadRequest(t *testing.T, seller int64, filename string, content []byte) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/send?seller=%v", seller), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestUploadLargerThanLimit(t *testing.T) {
+	cfg := config.Default()
+	cfg.MaxUploadSize = 1024
+	m, c := newTestServer(t, cfg)
+
+	rr := serve(m, newUploadRequest(t, 1, "prices.xlsx", bytes.Repeat([]byte("a"), 4096)))
+	if rr.Code != http.StatusRequestEntityTooLarge || rr.Body.String() != "upload is larger than 1024 bytes" {
+		t.Errorf("got %v, %v", rr.Code, rr.Body.String())
+	}
+	if jobs := c.Jobs.ListBySeller(1); len(jobs) != 0 {
+		t.Errorf("job is created for rejected upload: %+v", jobs)
+	}
+
+	if rr := serve(m, newUploadRequest(t, 1, "prices.csv", []byte("test"))); rr.Code != http.StatusOK {
+		t.Errorf("small upload: got %v, %v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestConcurrentUploadsAndPolls(t *testing.T) {
+	cfg := config.Default()
+	cfg.TempDir = t.TempDir()
+	cfg.StorageDir = t.TempDir()
+	m, c := newTestServer(t, cfg)
+	//несколько пачек на файл, чтобы опросы шли, пока задачи ещё сохраняют строки
+	rows := fixtures.Offers(1, 3*cfg.BatchSize+50)
+	content := buildFixture(t, fixtures.Sheet{Rows: rows})
+
+	const uploads = 20
+	jobIds := make(chan string, uploads)
+	uploadsWg := &sync.WaitGroup{}
+	streamsWg := &sync.WaitGroup{}
+	for i := 0; i < uploads; i++ {
+		uploadsWg.Add(1)
+		go func(seller int64) {
+			defer uploadsWg.Done()
+			rr := serve(m, newUploadRequest(t, seller, "prices.xlsx", content))
+			if rr.Code != http.StatusOK {
+				t.Errorf("upload returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+				return
+			}
+			jobId := rr.Body.String()
+			jobIds <- jobId
+
+			//поток событий открывается сразу после загрузки и закрывается, когда задача завершится
+			streamsWg.Add(1)
+			go func() {
+				defer streamsWg.Done()
+				rr := serve(m, httptest.NewRequest("GET", fmt.Sprintf("/proc/%v/events?seller=%v", jobId, seller), nil))
+				if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "event: finished") {
+					t.Errorf("events of job %v: got %v, %v", jobId, rr.Code, rr.Body.String())
+				}
+			}()
+		}(int64(i % 3))
+	}
+
+	stopPolls := make(chan struct{})
+	pollsWg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		pollsWg.Add(1)
+		go func(seller int64) {
+			defer pollsWg.Done()
+			for {
+				select {
+				case <-stopPolls:
+					return
+				default:
+				}
+				for _, job := range c.Jobs.ListBySeller(seller) {
+					req := httptest.NewRequest("GET", fmt.Sprintf("/proc?seller=%v&id=%v", seller, job.Id), nil)
+					if rr := serve(m, req); rr.Code != http.StatusOK {
+						t.Errorf("poll returned wrong status code: got %v, %v", rr.Code, rr.Body.String())
+					}
+				}
+				rr := serve(m, httptest.NewRequest("GET", fmt.Sprintf("/jobs?seller=%v", seller), nil))
+				if rr.Code != http.StatusOK {
+					t.Errorf("jobs list returned wrong status code: got %v, %v", rr.Code, rr.Body.String())
+				}
+			}
+		}(int64(i % 3))
+	}
+
+	uploadsWg.Wait()
+	close(jobIds)
+
+	unique := map[string]bool{}
+	for jobId := range jobIds {
+		if unique[jobId] {
+			t.Errorf("job id %v returned twice", jobId)
+		}
+		unique[jobId] = true
+	}
+	if len(unique) != uploads {
+		t.Errorf("got %v job ids want %v", len(unique), uploads)
+	}
+
+	timeout := time.After(30 * time.Second)
+	for jobId := range unique {
+		job, ok := c.Jobs.Get(jobId)
+		if !ok {
+			t.Fatalf("job %v is not registered", jobId)
+		}
+		select {
+		case <-job.Done():
+		case <-timeout:
+			t.Fatalf("job %v did not finish, status: %v", jobId, job.Status())
+		}
+		if job.Status() != finishedStatus(len(rows), 0) {
+			t.Errorf("job %v finished with unexpected status: %v", jobId, job.Status())
+		}
+	}
+	close(stopPolls)
+	pollsWg.Wait()
+	streamsWg.Wait()
+
+	for seller := int64(0); seller < 3; seller++ {
+		if products := sellerProducts(t, c, seller); !reflect.DeepEqual(products, expectedProducts(seller, rows)) {
+			t.Errorf("seller %v: got %v products want %v", seller, len(products), len(rows))
+		}
+	}
+}
+
+func TestProcEventsStream(t *testing.T) {
+	m, c := newTestServer(t, config.Default())
+	job := c.Jobs.Create(0)
+	job.SetStatus("working with sheets")
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		job.Progress.AddCreated(100)
+		job.Progress.AddError("row 5: price lower than zero")
+		job.Finish("finished")
+	}()
+
+	req, err := http.NewRequest("GET", "/proc/"+job.Id+"/events?seller=0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := serve(m, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	events := []string{}
+	for _, line := range strings.Split(rr.Body.String(), "\n") {
+		if strings.HasPrefix(line, "event: ") {
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		}
+	}
+	expected := "status,created,error,finished"
+	if strings.Join(events, ",") != expected {
+		t.Errorf("handler returned unexpected events: got %v want %v",
+			strings.Join(events, ","), expected)
+	}
+}
+
+func TestProcEventsStreamLastEventId(t *testing.T) {
+	m, c := newTestServer(t, config.Default())
+	job := c.Jobs.Create(0)
+	job.SetStatus("working with sheets")
+	job.Finish("finished")
+
+	for lastEventId, expected := range map[string]string{
+		"-1":    "status,finished",
+		"abc":   "status,finished",
+		"1":     "finished",
+		"100":   "",
+		"-1000": "status,finished",
+	} {
+		req := httptest.NewRequest("GET", "/proc/"+job.Id+"/events?seller=0", nil)
+		req.Header.Set("Last-Event-ID", lastEventId)
+		rr := serve(m, req)
+
+		events := []string{}
+		for _, line := range strings.Split(rr.Body.String(), "\n") {
+			if strings.HasPrefix(line, "event: ") {
+				events = append(events, strings.TrimPrefix(line, "event: "))
+			}
+		}
+		if rr.Code != http.StatusOK || strings.Join(events, ",") != expected {
+			t.Errorf("Last-Event-ID %v: got %v, events %v want %v", lastEventId, rr.Code, strings.Join(events, ","), expected)
+		}
+	}
+}
+
+func TestCallbackAfterFailedImport(t *testing.T) {
+	callbacks := make(chan *model.JobCallback, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callback := &model.JobCallback{}
+		if err := json.NewDecoder(r.Body).Decode(callback); err != nil {
+			t.Error(err)
+		}
+		callbacks <- callback
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.WebhookSecret = "secret"
+	m, c := newTestServer(t, cfg)
+	c.Notifier.AllowPrivateNetworks = true
+
+	req := newUploadRequest(t, 7, "prices.csv", []byte("test"))
+	req.URL.RawQuery += "&callback_url=" + url.QueryEscape(server.URL)
+	rr := serve(m, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload returned wrong status code: got %v, %v", rr.Code, rr.Body.String())
+	}
+	jobId := rr.Body.String()
+
+	select {
+	case callback := <-callbacks:
+		if callback.SellerId != 7 || callback.Id != jobId || callback.Status != "error: unsupported file type: csv" {
+			t.Errorf("unexpected callback: %+v, %+v", callback, callback.Job)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback was not sent")
+	}
+}
+
+func TestCallbackUrlIsRejected(t *testing.T) {
+	cfg := config.Default()
+	cfg.WebhookSecret = "secret"
+	m, _ := newTestServer(t, cfg)
+	unsigned, _ := newTestServer(t, config.Default())
+
+	for _, test := range []struct {
+		handler     http.Handler
+		callbackUrl string
+		expected    string
+	}{
+		{m, "http://localhost:6060/admin/status", "callback url must not point to a local or private address"},
+		{m, "http://10.0.0.5/hook", "callback url must not point to a local or private address"},
+		{unsigned, "https://example.com/hook", "callbacks are disabled: webhook secret is not set"},
+	} {
+		req := newUploadRequest(t, 7, "prices.xlsx", []byte("test"))
+		req.URL.RawQuery += "&callback_url=" + url.QueryEscape(test.callbackUrl)
+		rr := serve(test.handler, req)
+		if rr.Code != http.StatusInternalServerError || rr.Body.String() != test.expected {
+			t.Errorf("%v: got %v, %v want %v", test.callbackUrl, rr.Code, rr.Body.String(), test.expected)
+		}
+	}
+}
+
+func TestShutdownRejectsUploadsAndCleansTempFiles(t *testing.T) {
+	cfg := config.Default()
+	cfg.TempDir = t.TempDir()
+	leftovers := []string{filepath.Join(cfg.TempDir, "upload-123.xlsx"), filepath.Join(cfg.TempDir, "upload-456.json")}
+	for _, leftover := range leftovers {
+		if err := ioutil.WriteFile(leftover, []byte("test"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, c := newTestServer(t, cfg)
+
+	rr := serve(m, newUploadRequest(t, 0, "prices.txt", []byte("test")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload returned wrong status code: got %v, %v", rr.Code, rr.Body.String())
+	}
+	jobId := rr.Body.String()
+	c.Shutdown(5 * time.Second)
+
+	job, _ := c.Jobs.Get(jobId)
+	if !job.Finished() {
+		t.Errorf("shutdown returned before job %v finished", jobId)
+	}
+	for _, leftover := range leftovers {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("temp file %v was not removed: %v", leftover, err)
+		}
+	}
+
+	rr = serve(m, newUploadRequest(t, 0, "prices.xlsx", []byte("test")))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("upload after shutdown returned wrong status code: got %v, %v", rr.Code, rr.Body.String())
+	}
+}
+
+func unreachableDatabase() config.Database {
+	dbConfig := config.Default().Database
+	dbConfig.Host = "127.0.0.1"
+	dbConfig.Port = 1
+	dbConfig.ConnectTimeout = 0
+	return dbConfig
+}
+
+func TestHealthz(t *testing.T) {
+	m, _ := newTestServer(t, config.Default())
+
+	if rr := serve(m, httptest.NewRequest("GET", "/healthz", nil)); rr.Code != http.StatusOK || rr.Body.String() != "ok" {
+		t.Errorf("handler returned unexpected response: %v, %v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestReadyzWithUnreachableDb(t *testing.T) {
+	db, err := sql.Open("postgres", unreachableDatabase().DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := newServer(storage.NewPostgres(db), config.Default(), testLogger)
+	defer db.Close()
+
+	rr := serve(m, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+
+	result := map[string]string{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result["Database"] == "ok" || result["ActiveJobs"] != "0/20" {
+		t.Errorf("handler returned unexpected body: %v", rr.Body.String())
+	}
+}
+
+func TestServerClosesSlowHeaders(t *testing.T) {
+	cfg := config.Default()
+	cfg.ReadHeaderTimeout = 100 * time.Millisecond
+	m, _ := newTestServer(t, cfg)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newHTTPServer(listener.Addr().String(), m, cfg)
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	//заголовки не дописываются, сервер должен закрыть соединение сам
+	if _, err := conn.Write([]byte("GET /healthz HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("connection is not closed by server: %v", err)
+	}
+}
+
+func TestConnectDbGivesUp(t *testing.T) {
+	if _, err := connectDB(unreachableDatabase(), testLogger); err == nil {
+		t.Errorf("expected error for unreachable db")
+	}
+}
+
+func TestMetricsAfterFailedImport(t *testing.T) {
+	m, c := newTestServer(t, config.Default())
+	db, err := sql.Open("postgres", unreachableDatabase().DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c.Metrics.RegisterDB(db)
+
+	rr := serve(m, newUploadRequest(t, 0, "prices.txt", []byte("test")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload returned wrong status code: got %v, %v", rr.Code, rr.Body.String())
+	}
+	job, _ := c.Jobs.Get(rr.Body.String())
+	<-job.Done()
+	serve(m, httptest.NewRequest("POST", "/send?seller=test", nil))
+
+	rr = serve(newAdminServer(c, testLogger), httptest.NewRequest("GET", "/metrics", nil))
+
+	for _, expected := range []string{
+		`avito_uploads_total{status="accepted"} 1`,
+		`avito_uploads_total{status="failed"} 1`,
+		`avito_uploads_total{status="rejected"} 1`,
+		`avito_active_jobs`,
+		`go_sql_open_connections{db_name="postgres"}`,
+	} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("metrics do not contain %v", expected)
+		}
+	}
+}
+
+// failingBatchStore не сохраняет одну пачку, как при обрыве соединения с бд
+type failingBatchStore struct {
+	storage.Storage
+	key jobs.BatchKey
+}
+
+func (s *failingBatchStore) SaveBatch(ctx context.Context, jobId string, key jobs.BatchKey, batch *storage.Batch, checkpoint *jobs.Checkpoint) error {
+	if key == s.key {
+		return errors.New("connection reset")
+	}
+	return s.Storage.SaveBatch(ctx, jobId, key, batch, checkpoint)
+}
+
+func TestMetricsRowCounters(t *testing.T) {
+	cfg := config.Default()
+	cfg.TempDir = t.TempDir()
+	cfg.StorageDir = t.TempDir()
+	cfg.BatchSize = 2
+	m, c := newServer(&failingBatchStore{Storage: storage.NewMemory()}, cfg, testLogger)
+
+	//первая пачка с отклонённой строкой не сохраняется: строка считается один раз, по своей причине
+	runImport(t, m, c, 1, fixtures.Sheet{Rows: [][]string{
+		fixtures.Offer(1, "apple", 100, 1, true),
+		{"2", "bad price", "free", "1", "true"},
+		fixtures.Offer(3, "pear", 100, 1, true),
+		fixtures.Offer(4, "plum", 100, 1, false),
+	}})
+
+	if rr := serve(m, httptest.NewRequest("GET", "/metrics", nil)); rr.Code != http.StatusNotFound {
+		t.Errorf("metrics on public port: got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+	rr := serve(newAdminServer(c, testLogger), httptest.NewRequest("GET", "/metrics", nil))
+	for _, expected := range []string{
+		`avito_rows_processed_total 4`,
+		`avito_rows_failed_total{reason="database"} 1`,
+		`avito_rows_failed_total{reason="price"} 1`,
+		`avito_uploads_total{status="finished"} 1`,
+	} {
+		if !strings.Contains(rr.Body.String(), expected+"\n") {
+			t.Errorf("metrics do not contain %v", expected)
+		}
+	}
+}
+
+func TestImportLogsCarryJobAndRequest(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer := &lockedWriter{w: buf}
+	logger := slog.New(slog.NewJSONHandler(writer, nil))
+	m, c := newServer(storage.NewMemory(), config.Default(), logger)
+
+	req := newUploadRequest(t, 5, "prices.txt", []byte("test"))
+	req.Header.Set(logging.RequestIdHeader, "req-42")
+	rr := serve(m, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload returned wrong status code: got %v, %v", rr.Code, rr.Body.String())
+	}
+	jobId := rr.Body.String()
+	job, _ := c.Jobs.Get(jobId)
+	<-job.Done()
+
+	writer.mutex.Lock()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	writer.mutex.Unlock()
+	if len(lines) < 3 {
+		t.Fatalf("got %v log lines: %v", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["request_id"] != "req-42" {
+			t.Errorf("log line without request id: %v", line)
+		}
+		//access-лог пишется по запросу, а не по задаче
+		if entry["msg"] == "request completed" {
+			continue
+		}
+		if entry["job_id"] != jobId || entry["seller_id"] != float64(5) {
+			t.Errorf("log line without job context: %v", line)
+		}
+	}
+}
+
+type lockedWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	return lw.w.Write(p)
+}
+
+func TestPprofNotOnPublicPort(t *testing.T) {
+	m, c := newTestServer(t, config.Default())
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap", "/admin/status"} {
+		rr := serve(m, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%v: got status %v want %v", path, rr.Code, http.StatusNotFound)
+		}
+	}
+
+	rr := serve(newAdminServer(c, testLogger), httptest.NewRequest("GET", "/debug/pprof/heap?debug=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("admin server returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestAdminAuthOnPublicPort(t *testing.T) {
+	cfg := config.Default()
+	cfg.Admin = config.Admin{User: "admin", Password: "s3cret"}
+	m, _ := newTestServer(t, cfg)
+
+	for _, credentials := range [][2]string{{"", ""}, {"admin", "wrong"}, {"seller", "s3cret"}} {
+		req := httptest.NewRequest("GET", "/debug/pprof/", nil)
+		if credentials[0] != "" {
+			req.SetBasicAuth(credentials[0], credentials[1])
+		}
+		if rr := serve(m, req); rr.Code != http.StatusUnauthorized {
+			t.Errorf("%v: got status %v want %v", credentials, rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/debug/pprof/", nil)
+	req.SetBasicAuth("admin", "s3cret")
+	if rr := serve(m, req); rr.Code != http.StatusOK {
+		t.Errorf("got status %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestAdminStatusListsActiveJobs(t *testing.T) {
+	_, c := newTestServer(t, config.Default())
+	active := c.Jobs.Create(1)
+	active.SetStatus("working with sheets")
+	c.Jobs.Create(2).Finish("finished")
+
+	rr := serve(newAdminServer(c, testLogger), httptest.NewRequest("GET", "/admin/status", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %v want %v", rr.Code, http.StatusOK)
+	}
+
+	result := struct {
+		Stopping bool
+		Jobs     []*model.SellerJob
+	}{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Stopping || len(result.Jobs) != 1 {
+		t.Fatalf("unexpected status: %v", rr.Body.String())
+	}
+	if job := result.Jobs[0]; job.Id != active.Id || job.SellerId != 1 || job.Status != "working with sheets" {
+		t.Errorf("unexpected job: %v", rr.Body.String())
+	}
+}
+
+func TestRouterMethodsAndCORS(t *testing.T) {
+	cfg := config.Default()
+	cfg.CORSOrigins = []string{"https://seller.example"}
+	m, _ := newTestServer(t, cfg)
+
+	if rr := serve(m, httptest.NewRequest("GET", "/send?seller=0", nil)); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %v want %v", rr.Code, http.StatusMethodNotAllowed)
+	}
+	if rr := serve(m, httptest.NewRequest("GET", "/unknown", nil)); rr.Code != http.StatusNotFound {
+		t.Errorf("got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	req := httptest.NewRequest("OPTIONS", "/send?seller=0", nil)
+	req.Header.Set("Origin", "https://seller.example")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr := serve(m, req)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "https://seller.example" {
+		t.Errorf("preflight is not allowed: %v, %v", rr.Code, rr.Header())
+	}
+	if rr.Header().Get(logging.RequestIdHeader) == "" {
+		t.Errorf("response without request id")
+	}
+}
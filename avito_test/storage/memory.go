@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"avito_test/jobs"
+	"avito_test/model"
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type productKey struct {
+	SellerId int64
+	OfferId  int64
+}
+
+type memoryJob struct {
+	StoredJob
+	seq      int
+	status   string
+	finished bool
+}
+
+// Memory хранит всё в памяти процесса, нужна для тестов без бд и ведёт себя так же, как Postgres
+type Memory struct {
+	//часы, по которым проставляется время создания и изменения товаров, в тестах подменяются
+	Now      func() time.Time
+	mutex    sync.Mutex
+	seq      int
+	products map[productKey]*model.Product
+	jobs     map[string]*memoryJob
+	batches  map[string]map[jobs.BatchKey]*jobs.Checkpoint
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		Now:      time.Now,
+		products: map[productKey]*model.Product{},
+		jobs:     map[string]*memoryJob{},
+		batches:  map[string]map[jobs.BatchKey]*jobs.Checkpoint{},
+	}
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) CheckSchema(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) FindProducts(ctx context.Context, filter ProductFilter) ([]*model.Product, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name := strings.ToLower(filter.Name)
+	products := []*model.Product{}
+	for key, product := range m.products {
+		if filter.SellerId != nil && key.SellerId != *filter.SellerId {
+			continue
+		}
+		if filter.OfferId != nil && key.OfferId != *filter.OfferId {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(product.Name), name) {
+			continue
+		}
+		if filter.UpdatedSince != nil && product.UpdatedAt.Before(*filter.UpdatedSince) {
+			continue
+		}
+		if !filter.IncludeUnavailable && !product.Available {
+			continue
+		}
+		products = append(products, copyProduct(product))
+	}
+	sort.Slice(products, func(i, j int) bool {
+		if products[i].SellerId != products[j].SellerId {
+			return products[i].SellerId < products[j].SellerId
+		}
+		return products[i].OfferId < products[j].OfferId
+	})
+	return products, nil
+}
+
+func (m *Memory) EachProduct(ctx context.Context, filter ProductFilter, fn func(product *model.Product) error) error {
+	//товары и так в памяти, fn вызывается уже без блокировки, чтобы он мог обращаться к хранилищу
+	products, err := m.FindProducts(ctx, filter)
+	if err != nil {
+		return err
+	}
+	for _, product := range products {
+		if err := fn(product); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) SaveBatch(ctx context.Context, jobId string, key jobs.BatchKey, batch *Batch, checkpoint *jobs.Checkpoint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.jobs[jobId]; !ok {
+		return fmt.Errorf("error in saving checkpoint: %w", ErrUnknownJob)
+	}
+	if _, ok := m.batches[jobId][key]; ok {
+		return fmt.Errorf("error in saving checkpoint: %w", ErrCheckpointExists)
+	}
+	//как и в postgres, offer id хранится в integer
+	for _, offerId := range batch.Unavailable {
+		if offerId > math.MaxInt32 || offerId < math.MinInt32 {
+			return fmt.Errorf("error in marking products unavailable: offer id %v is out of integer range", offerId)
+		}
+	}
+	//как и postgres, один запрос не может изменить товар дважды
+	seen := map[int64]bool{}
+	for _, product := range batch.Upsert {
+		if product.OfferId > math.MaxInt32 || product.OfferId < math.MinInt32 {
+			return fmt.Errorf("error in upsert data: offer id %v is out of integer range", product.OfferId)
+		}
+		if seen[product.OfferId] {
+			return fmt.Errorf("error in upsert data: offer id %v is repeated in batch", product.OfferId)
+		}
+		seen[product.OfferId] = true
+	}
+
+	now := m.Now()
+	for _, product := range batch.Upsert {
+		key := productKey{batch.SellerId, product.OfferId}
+		saved := copyProduct(product)
+		saved.SellerId = batch.SellerId
+		saved.Available = true
+		saved.CreatedAt, saved.UpdatedAt = now, now
+		//как и в postgres, время изменения сдвигается, только если поля товара поменялись
+		if existing, ok := m.products[key]; ok {
+			saved.CreatedAt = existing.CreatedAt
+			if sameFields(existing, saved) {
+				saved.UpdatedAt = existing.UpdatedAt
+			}
+		}
+		m.products[key] = saved
+	}
+	checkpoint.Created = int64(len(batch.Upsert))
+
+	checkpoint.Deleted = 0
+	for _, offerId := range batch.Unavailable {
+		if product, ok := m.products[productKey{batch.SellerId, offerId}]; ok && product.Available {
+			product.Available = false
+			product.UpdatedAt = now
+			checkpoint.Deleted++
+		}
+	}
+
+	if m.batches[jobId] == nil {
+		m.batches[jobId] = map[jobs.BatchKey]*jobs.Checkpoint{}
+	}
+	saved := *checkpoint
+	saved.ErrorStrings = append([]string{}, checkpoint.ErrorStrings...)
+	m.batches[jobId][key] = &saved
+	return nil
+}
+
+// copyProduct копирует товар вместе со списками, чтобы хранилище не делило их с вызывающим кодом
+func copyProduct(product *model.Product) *model.Product {
+	copied := *product
+	copied.Category = append([]string(nil), product.Category...)
+	copied.Images = append([]string(nil), product.Images...)
+	return &copied
+}
+
+// sameFields сравнивает товары без учёта времени создания и изменения
+func sameFields(a *model.Product, b *model.Product) bool {
+	first, second := *a, *b
+	first.CreatedAt, first.UpdatedAt = time.Time{}, time.Time{}
+	second.CreatedAt, second.UpdatedAt = time.Time{}, time.Time{}
+	return reflect.DeepEqual(&first, &second)
+}
+
+func (m *Memory) InsertJob(ctx context.Context, job *StoredJob, status string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.jobs[job.Id]; ok {
+		return fmt.Errorf("job %v already exists", job.Id)
+	}
+	m.seq++
+	stored := *job
+	stored.Sheets = append([]string{}, job.Sheets...)
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = m.Now()
+	}
+	m.jobs[job.Id] = &memoryJob{
+		StoredJob: stored,
+		seq:       m.seq,
+		status:    status,
+	}
+	return nil
+}
+
+func (m *Memory) SetJobStatus(ctx context.Context, jobId string, status string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if job, ok := m.jobs[jobId]; ok {
+		job.status = status
+	}
+	return nil
+}
+
+func (m *Memory) FinishJob(ctx context.Context, jobId string, status string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if job, ok := m.jobs[jobId]; ok {
+		job.status = status
+		job.finished = true
+	}
+	return nil
+}
+
+func (m *Memory) UnfinishedJobs(ctx context.Context) ([]*StoredJob, error) {
+	return m.listJobs(func(job *memoryJob) bool {
+		return !job.finished
+	}), nil
+}
+
+func (m *Memory) GetJob(ctx context.Context, jobId string) (*StoredJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[jobId]
+	if !ok {
+		return nil, ErrUnknownJob
+	}
+	return job.stored(), nil
+}
+
+func (m *Memory) SellerJobs(ctx context.Context, sellerId int64) ([]*StoredJob, error) {
+	return m.listJobs(func(job *memoryJob) bool {
+		return job.SellerId == sellerId
+	}), nil
+}
+
+// listJobs возвращает копии задач, подходящих под filter, в порядке создания
+func (m *Memory) listJobs(filter func(job *memoryJob) bool) []*StoredJob {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	found := []*memoryJob{}
+	for _, job := range m.jobs {
+		if filter(job) {
+			found = append(found, job)
+		}
+	}
+	//время создания задаётся вызывающим и может совпадать, при равенстве задачи идут в порядке вставки
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].CreatedAt.Equal(found[j].CreatedAt) {
+			return found[i].CreatedAt.Before(found[j].CreatedAt)
+		}
+		return found[i].seq < found[j].seq
+	})
+	jobs := make([]*StoredJob, len(found))
+	for i, job := range found {
+		jobs[i] = job.stored()
+	}
+	return jobs
+}
+
+func (job *memoryJob) stored() *StoredJob {
+	stored := job.StoredJob
+	stored.Sheets = append([]string{}, job.Sheets...)
+	stored.Status = job.status
+	stored.Finished = job.finished
+	return &stored
+}
+
+func (m *Memory) Checkpoints(ctx context.Context, jobId string) (map[jobs.BatchKey]*jobs.Checkpoint, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	checkpoints := map[jobs.BatchKey]*jobs.Checkpoint{}
+	for key, checkpoint := range m.batches[jobId] {
+		saved := *checkpoint
+		saved.ErrorStrings = append([]string{}, checkpoint.ErrorStrings...)
+		checkpoints[key] = &saved
+	}
+	return checkpoints, nil
+}
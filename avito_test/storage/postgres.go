@@ -0,0 +1,402 @@
+package storage
+
+import (
+	"avito_test/jobs"
+	"avito_test/migrations"
+	"avito_test/model"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// коды ошибок postgres, которые хранилище переводит в свои ошибки
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
+)
+
+type Postgres struct {
+	DB *sql.DB
+	//вызывается после каждого запроса пачки с его названием (upsert или delete) и длительностью
+	OnBatchQuery func(operation string, duration time.Duration)
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{
+		DB:           db,
+		OnBatchQuery: func(string, time.Duration) {},
+	}
+}
+
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.DB.PingContext(ctx)
+}
+
+// CheckSchema сверяет версию схемы в бд с последней миграцией, встроенной в бинарник
+func (p *Postgres) CheckSchema(ctx context.Context) error {
+	all, err := migrations.Load()
+	if err != nil {
+		return err
+	}
+	migrator := &migrations.Migrator{DB: p.DB, Migrations: all}
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version != migrator.Latest() {
+		return fmt.Errorf("schema is not migrated: version %v of %v", version, migrator.Latest())
+	}
+	return nil
+}
+
+func (p *Postgres) FindProducts(ctx context.Context, filter ProductFilter) ([]*model.Product, error) {
+	products := []*model.Product{}
+	err := p.EachProduct(ctx, filter, func(product *model.Product) error {
+		products = append(products, product)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (p *Postgres) EachProduct(ctx context.Context, filter ProductFilter, fn func(product *model.Product) error) error {
+	conditions := []string{}
+	args := []interface{}{}
+	if filter.SellerId != nil {
+		args = append(args, *filter.SellerId)
+		conditions = append(conditions, fmt.Sprintf("seller_id = $%v", len(args)))
+	}
+	if filter.OfferId != nil {
+		args = append(args, *filter.OfferId)
+		conditions = append(conditions, fmt.Sprintf("offer_id = $%v", len(args)))
+	}
+	if filter.Name != "" {
+		args = append(args, "%"+escapeLike(filter.Name)+"%")
+		conditions = append(conditions, fmt.Sprintf("name ilike $%v", len(args)))
+	}
+	if filter.UpdatedSince != nil {
+		args = append(args, *filter.UpdatedSince)
+		conditions = append(conditions, fmt.Sprintf("updated_at >= $%v", len(args)))
+	}
+	if !filter.IncludeUnavailable {
+		conditions = append(conditions, "available")
+	}
+
+	query := "select seller_id, offer_id, name, price, currency, quantity, description, category, brand, barcode, " +
+		"images, weight, length, width, height, available, created_at, updated_at from product"
+	if len(conditions) != 0 {
+		query += " where " + strings.Join(conditions, " and ")
+	}
+	rows, err := p.DB.QueryContext(ctx, query+" order by seller_id, offer_id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		pr := &model.Product{}
+		err = rows.Scan(
+			&pr.SellerId,
+			&pr.OfferId,
+			&pr.Name,
+			&pr.Price,
+			&pr.Currency,
+			&pr.Quantity,
+			&pr.Description,
+			pq.Array(&pr.Category),
+			&pr.Brand,
+			&pr.Barcode,
+			pq.Array(&pr.Images),
+			&pr.Weight,
+			&pr.Length,
+			&pr.Width,
+			&pr.Height,
+			&pr.Available,
+			&pr.CreatedAt,
+			&pr.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		//пустые списки отдаются как nil, так же как их хранит Memory
+		if len(pr.Category) == 0 {
+			pr.Category = nil
+		}
+		if len(pr.Images) == 0 {
+			pr.Images = nil
+		}
+		if err := fn(pr); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// escapeLike экранирует спецсимволы ilike, чтобы название искалось как обычная подстрока
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (p *Postgres) SaveBatch(ctx context.Context, jobId string, key jobs.BatchKey, batch *Batch, checkpoint *jobs.Checkpoint) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(batch.Upsert) != 0 {
+		count := len(batch.Upsert)
+		offerIds := make([]int64, count)
+		names := make([]string, count)
+		prices := make([]string, count)
+		currencies := make([]string, count)
+		quantities := make([]int64, count)
+		descriptions := make([]string, count)
+		categories := make([]string, count)
+		brands := make([]string, count)
+		barcodes := make([]string, count)
+		images := make([]string, count)
+		weights := make([]int64, count)
+		lengths := make([]int64, count)
+		widths := make([]int64, count)
+		heights := make([]int64, count)
+		for i, product := range batch.Upsert {
+			offerIds[i] = product.OfferId
+			names[i] = product.Name
+			//цена передаётся текстом, чтобы не потерять точность
+			prices[i] = product.Price.String()
+			currencies[i] = product.Currency
+			quantities[i] = int64(product.Quantity)
+			descriptions[i] = product.Description
+			//списки разной длины не собрать в один двумерный массив, поэтому каждый передаётся литералом массива
+			categories[i] = arrayLiteral(product.Category)
+			brands[i] = product.Brand
+			barcodes[i] = product.Barcode
+			images[i] = arrayLiteral(product.Images)
+			weights[i] = int64(product.Weight)
+			lengths[i] = int64(product.Length)
+			widths[i] = int64(product.Width)
+			heights[i] = int64(product.Height)
+		}
+
+		start := time.Now()
+		result, err := tx.ExecContext(
+			ctx,
+			"insert into product (seller_id, offer_id, name, price, currency, quantity, description, category, brand, "+
+				"barcode, images, weight, length, width, height, available) "+
+				"select $1::integer, offer_id, name, price, currency, quantity, description, category::text[], brand, "+
+				"barcode, images::text[], weight, length, width, height, true "+
+				"from unnest($2::integer[], $3::varchar[], $4::numeric[], $5::varchar[], $6::integer[], $7::text[], "+
+				"$8::text[], $9::varchar[], $10::varchar[], $11::text[], $12::integer[], $13::integer[], $14::integer[], $15::integer[]) "+
+				"as t(offer_id, name, price, currency, quantity, description, category, brand, barcode, images, "+
+				"weight, length, width, height) "+
+				"on conflict on constraint product_id do update set name = excluded.name, price = excluded.price, "+
+				"currency = excluded.currency, quantity = excluded.quantity, description = excluded.description, "+
+				"category = excluded.category, brand = excluded.brand, barcode = excluded.barcode, images = excluded.images, "+
+				"weight = excluded.weight, length = excluded.length, width = excluded.width, height = excluded.height, "+
+				"available = excluded.available, "+
+				//повторная загрузка того же файла не должна отдавать все товары в инкрементальную синхронизацию
+				"updated_at = case when (product.name, product.price, product.currency, product.quantity, "+
+				"product.description, product.category, product.brand, product.barcode, product.images, product.weight, "+
+				"product.length, product.width, product.height, product.available) is distinct from (excluded.name, "+
+				"excluded.price, excluded.currency, excluded.quantity, excluded.description, excluded.category, "+
+				"excluded.brand, excluded.barcode, excluded.images, excluded.weight, excluded.length, excluded.width, "+
+				"excluded.height, excluded.available) then now() else product.updated_at end",
+			batch.SellerId,
+			pq.Array(offerIds),
+			pq.Array(names),
+			pq.Array(prices),
+			pq.Array(currencies),
+			pq.Array(quantities),
+			pq.Array(descriptions),
+			pq.Array(categories),
+			pq.Array(brands),
+			pq.Array(barcodes),
+			pq.Array(images),
+			pq.Array(weights),
+			pq.Array(lengths),
+			pq.Array(widths),
+			pq.Array(heights),
+		)
+		if err != nil {
+			return fmt.Errorf("error in upsert data: %v", err)
+		}
+		p.OnBatchQuery("upsert", time.Since(start))
+		checkpoint.Created, _ = result.RowsAffected()
+	}
+
+	if len(batch.Unavailable) != 0 {
+		start := time.Now()
+		result, err := tx.ExecContext(
+			ctx,
+			//товар не удаляется, а снимается с продажи, чтобы инкрементальная синхронизация узнала об этом
+			"update product set available = false, updated_at = now() "+
+				"where seller_id = $1 and offer_id = any($2::integer[]) and available",
+			batch.SellerId,
+			pq.Array(batch.Unavailable),
+		)
+		if err != nil {
+			return fmt.Errorf("error in marking products unavailable: %v", err)
+		}
+		p.OnBatchQuery("delete", time.Since(start))
+		checkpoint.Deleted, _ = result.RowsAffected()
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"insert into import_batch (job_id, sheet, batch, created, deleted, errors) values ($1, $2, $3, $4, $5, $6)",
+		jobId,
+		key.Sheet,
+		key.Batch,
+		checkpoint.Created,
+		checkpoint.Deleted,
+		pq.Array(checkpoint.ErrorStrings),
+	)
+	if err != nil {
+		return fmt.Errorf("error in saving checkpoint: %w", translate(err))
+	}
+	return tx.Commit()
+}
+
+// arrayLiteral записывает список строк литералом массива postgres, например {"a","b"}
+func arrayLiteral(values []string) string {
+	if len(values) == 0 {
+		return "{}"
+	}
+	literal, _ := pq.StringArray(values).Value()
+	return literal.(string)
+}
+
+func translate(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case foreignKeyViolation:
+			return ErrUnknownJob
+		case uniqueViolation:
+			return ErrCheckpointExists
+		}
+	}
+	return err
+}
+
+func (p *Postgres) InsertJob(ctx context.Context, job *StoredJob, status string) error {
+	var createdAt interface{}
+	if !job.CreatedAt.IsZero() {
+		createdAt = job.CreatedAt
+	}
+	_, err := p.DB.ExecContext(
+		ctx,
+		"insert into import_job (id, seller_id, file_path, callback_url, batch_size, sheets, status, created_at) "+
+			"values ($1, $2, $3, $4, $5, coalesce($6::text[], '{}'), $7, coalesce($8::timestamptz, now()))",
+		job.Id,
+		job.SellerId,
+		job.FilePath,
+		job.CallbackUrl,
+		job.BatchSize,
+		pq.Array(job.Sheets),
+		status,
+		createdAt,
+	)
+	return err
+}
+
+func (p *Postgres) SetJobStatus(ctx context.Context, jobId string, status string) error {
+	_, err := p.DB.ExecContext(ctx, "update import_job set status = $2 where id = $1", jobId, status)
+	return err
+}
+
+func (p *Postgres) FinishJob(ctx context.Context, jobId string, status string) error {
+	_, err := p.DB.ExecContext(ctx, "update import_job set status = $2, finished = true where id = $1", jobId, status)
+	return err
+}
+
+// jobColumns - колонки import_job в порядке, в котором их читает selectJobs
+const jobColumns = "id, seller_id, file_path, callback_url, batch_size, sheets, status, finished, created_at"
+
+func (p *Postgres) UnfinishedJobs(ctx context.Context) ([]*StoredJob, error) {
+	return p.selectJobs(ctx, "select "+jobColumns+" from import_job where not finished order by created_at")
+}
+
+func (p *Postgres) GetJob(ctx context.Context, jobId string) (*StoredJob, error) {
+	//id в таблице - uuid, на строку другого вида Postgres ответил бы ошибкой приведения типа
+	if _, err := uuid.Parse(jobId); err != nil {
+		return nil, ErrUnknownJob
+	}
+	jobs, err := p.selectJobs(ctx, "select "+jobColumns+" from import_job where id = $1", jobId)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, ErrUnknownJob
+	}
+	return jobs[0], nil
+}
+
+func (p *Postgres) SellerJobs(ctx context.Context, sellerId int64) ([]*StoredJob, error) {
+	return p.selectJobs(ctx, "select "+jobColumns+" from import_job where seller_id = $1 order by created_at", sellerId)
+}
+
+func (p *Postgres) selectJobs(ctx context.Context, query string, args ...interface{}) ([]*StoredJob, error) {
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*StoredJob{}
+	for rows.Next() {
+		job := &StoredJob{}
+		err = rows.Scan(
+			&job.Id,
+			&job.SellerId,
+			&job.FilePath,
+			&job.CallbackUrl,
+			&job.BatchSize,
+			pq.Array(&job.Sheets),
+			&job.Status,
+			&job.Finished,
+			&job.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (p *Postgres) Checkpoints(ctx context.Context, jobId string) (map[jobs.BatchKey]*jobs.Checkpoint, error) {
+	rows, err := p.DB.QueryContext(
+		ctx,
+		"select sheet, batch, created, deleted, errors from import_batch where job_id = $1",
+		jobId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := map[jobs.BatchKey]*jobs.Checkpoint{}
+	for rows.Next() {
+		key := jobs.BatchKey{}
+		checkpoint := &jobs.Checkpoint{}
+		err = rows.Scan(
+			&key.Sheet,
+			&key.Batch,
+			&checkpoint.Created,
+			&checkpoint.Deleted,
+			pq.Array(&checkpoint.ErrorStrings),
+		)
+		if err != nil {
+			return nil, err
+		}
+		checkpoints[key] = checkpoint
+	}
+	return checkpoints, rows.Err()
+}
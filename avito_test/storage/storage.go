@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"avito_test/jobs"
+	"avito_test/model"
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrCheckpointExists возвращается, если пачка задачи уже была закоммичена
+	ErrCheckpointExists = errors.New("batch is already committed")
+	ErrUnknownJob       = errors.New("unknown job")
+)
+
+// ProductFilter - условия поиска товаров, пустые условия не учитываются
+type ProductFilter struct {
+	SellerId *int64
+	OfferId  *int64
+	//подстрока названия без учёта регистра
+	Name string
+	//товары, изменённые в этот момент или позже, для инкрементальной синхронизации
+	UpdatedSince *time.Time
+	//вместе со снятыми с продажи товарами, иначе ищутся только доступные
+	IncludeUnavailable bool
+}
+
+// Batch - изменения товаров продавца по одной пачке строк файла
+type Batch struct {
+	SellerId int64
+	Upsert   []*model.Product
+	//offer id товаров, которые нужно снять с продажи: товар не удаляется, а остаётся с available = false
+	Unavailable []int64
+}
+
+type StoredJob struct {
+	Id          string
+	SellerId    int64
+	FilePath    string
+	CallbackUrl string
+	BatchSize   int
+	//листы, выбранные при загрузке, пустой - все видимые листы
+	Sheets []string
+	//время создания задачи, по нему упорядочены списки задач; пустое при InsertJob - текущее время хранилища
+	CreatedAt time.Time
+	//статус заполняется только при чтении задачи, InsertJob получает его отдельным параметром
+	Status   string
+	Finished bool
+}
+
+// Storage хранит товары и задачи импорта. Изменения пачки и её checkpoint сохраняются атомарно,
+// чтобы после падения задача продолжилась ровно с первой незакоммиченной пачки
+type Storage interface {
+	Ping(ctx context.Context) error
+	// CheckSchema сообщает, готово ли хранилище к работе (например, применены ли миграции)
+	CheckSchema(ctx context.Context) error
+
+	// FindProducts возвращает товары, отсортированные по продавцу и offer id
+	FindProducts(ctx context.Context, filter ProductFilter) ([]*model.Product, error)
+	// EachProduct передаёт товары в fn по одному в том же порядке, не собирая их в память,
+	// ошибка fn прекращает обход и возвращается
+	EachProduct(ctx context.Context, filter ProductFilter, fn func(product *model.Product) error) error
+	// SaveBatch применяет изменения пачки и записывает checkpoint, в checkpoint проставляются
+	// количества созданных или обновлённых и снятых с продажи товаров
+	SaveBatch(ctx context.Context, jobId string, key jobs.BatchKey, batch *Batch, checkpoint *jobs.Checkpoint) error
+
+	InsertJob(ctx context.Context, job *StoredJob, status string) error
+	// SetJobStatus не завершает задачу, прерванная задача будет возобновлена при следующем старте
+	SetJobStatus(ctx context.Context, jobId string, status string) error
+	FinishJob(ctx context.Context, jobId string, status string) error
+	// UnfinishedJobs возвращает незавершённые задачи в порядке создания
+	UnfinishedJobs(ctx context.Context) ([]*StoredJob, error)
+	// GetJob возвращает задачу вместе со статусом, для неизвестного id - ErrUnknownJob
+	GetJob(ctx context.Context, jobId string) (*StoredJob, error)
+	// SellerJobs возвращает задачи продавца вместе со статусами в порядке создания
+	SellerJobs(ctx context.Context, sellerId int64) ([]*StoredJob, error)
+	Checkpoints(ctx context.Context, jobId string) (map[jobs.BatchKey]*jobs.Checkpoint, error)
+}
@@ -0,0 +1,421 @@
+package storage
+
+import (
+	"avito_test/jobs"
+	"avito_test/migrations"
+	"avito_test/model"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// тестовые продавцы берутся из верхней половины int32, чтобы не задеть данные локальной бд
+const testSellers = 1 << 30
+
+func newSeller() int64 {
+	return testSellers + rand.Int63n(testSellers-1)
+}
+
+func TestMemory(t *testing.T) {
+	testStorage(t, func(t *testing.T) Storage {
+		return NewMemory()
+	})
+}
+
+// TestPostgres прогоняет те же проверки на настоящей бд, адрес которой задаётся в TEST_DATABASE_DSN
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrator, err := migrations.New(db, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	testStorage(t, func(t *testing.T) Storage {
+		t.Cleanup(func() {
+			db.Exec("delete from product where seller_id >= $1", testSellers)
+			db.Exec("delete from import_job where seller_id >= $1", testSellers)
+		})
+		return NewPostgres(db)
+	})
+}
+
+func testStorage(t *testing.T, newStorage func(t *testing.T) Storage) {
+	ctx := context.Background()
+
+	newJob := func(t *testing.T, store Storage, seller int64) string {
+		jobId := uuid.New().String()
+		if err := store.InsertJob(ctx, &StoredJob{Id: jobId, SellerId: seller, FilePath: "uploads/" + jobId + ".xlsx", BatchSize: 100}, "new"); err != nil {
+			t.Fatal(err)
+		}
+		return jobId
+	}
+	find := func(t *testing.T, store Storage, filter ProductFilter) []*model.Product {
+		products, err := store.FindProducts(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return products
+	}
+
+	t.Run("find", func(t *testing.T) {
+		store := newStorage(t)
+		seller, other := newSeller(), newSeller()
+		jobId := newJob(t, store, seller)
+		err := store.SaveBatch(ctx, jobId, jobs.BatchKey{}, &Batch{SellerId: seller, Upsert: []*model.Product{
+			{
+				OfferId: 3, Name: "Pear", Price: 30, Currency: "RUB", Quantity: 3,
+				Description: "Сочная груша", Category: []string{"Фрукты", "Груши"}, Brand: "Сад", Barcode: "4006381333931",
+				Images: []string{"https://img.example.com/pear.jpg", `https://img.example.com/"pear",2.jpg`},
+				Weight: 180, Length: 60, Width: 60, Height: 90,
+			},
+			{OfferId: 1, Name: "Red apple", Price: 129999, Currency: "USD", Quantity: 1},
+			{OfferId: 2, Name: "green APPLE 100%", Price: 20, Quantity: 2},
+		}}, &jobs.Checkpoint{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherJobId := newJob(t, store, other)
+		err = store.SaveBatch(ctx, otherJobId, jobs.BatchKey{}, &Batch{SellerId: other, Upsert: []*model.Product{
+			{OfferId: 1, Name: "Apple", Price: 10, Quantity: 1},
+		}}, &jobs.Checkpoint{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		products := find(t, store, ProductFilter{SellerId: &seller})
+		if len(products) != 3 || products[0].OfferId != 1 || products[2].OfferId != 3 {
+			t.Fatalf("products are not found or not sorted: %+v", products)
+		}
+		if product := products[0]; product.SellerId != seller || product.Name != "Red apple" || product.Price != 129999 || product.Currency != "USD" || product.Quantity != 1 {
+			t.Errorf("unexpected product: %+v", product)
+		}
+		pear := &model.Product{
+			SellerId: seller, OfferId: 3, Name: "Pear", Price: 30, Currency: "RUB", Quantity: 3,
+			Description: "Сочная груша", Category: []string{"Фрукты", "Груши"}, Brand: "Сад", Barcode: "4006381333931",
+			Images: []string{"https://img.example.com/pear.jpg", `https://img.example.com/"pear",2.jpg`},
+			Weight: 180, Length: 60, Width: 60, Height: 90, Available: true,
+		}
+		if products[2].CreatedAt.IsZero() || !products[2].UpdatedAt.Equal(products[2].CreatedAt) {
+			t.Errorf("timestamps are not set: %+v", products[2])
+		}
+		pear.CreatedAt, pear.UpdatedAt = products[2].CreatedAt, products[2].UpdatedAt
+		if !reflect.DeepEqual(products[2], pear) {
+			t.Errorf("got product %+v want %+v", products[2], pear)
+		}
+		if products[0].Category != nil || products[0].Images != nil {
+			t.Errorf("empty lists are not nil: %+v", products[0])
+		}
+		if products := find(t, store, ProductFilter{SellerId: &seller, Name: "apple"}); len(products) != 2 {
+			t.Errorf("name search is case sensitive: %+v", products)
+		}
+		offerId := int64(1)
+		if products := find(t, store, ProductFilter{SellerId: &seller, OfferId: &offerId}); len(products) != 1 {
+			t.Errorf("got %v products by offer id want 1", len(products))
+		}
+		//спецсимволы ищутся как обычные символы
+		for name, expected := range map[string]int{"100%": 1, "%": 1, "_": 0, "' or '1'='1": 0} {
+			if products := find(t, store, ProductFilter{SellerId: &seller, Name: name}); len(products) != expected {
+				t.Errorf("name %q: got %v products want %v", name, len(products), expected)
+			}
+		}
+	})
+
+	t.Run("each product", func(t *testing.T) {
+		store := newStorage(t)
+		seller := newSeller()
+		err := store.SaveBatch(ctx, newJob(t, store, seller), jobs.BatchKey{}, &Batch{SellerId: seller, Upsert: []*model.Product{
+			{OfferId: 3, Name: "c", Price: 30, Quantity: 3},
+			{OfferId: 1, Name: "a", Price: 10, Quantity: 1, Category: []string{"x"}},
+			{OfferId: 2, Name: "b", Price: 20, Quantity: 2},
+		}}, &jobs.Checkpoint{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filter := ProductFilter{SellerId: &seller}
+		products := []*model.Product{}
+		err = store.EachProduct(ctx, filter, func(product *model.Product) error {
+			products = append(products, product)
+			return nil
+		})
+		if expected := find(t, store, filter); err != nil || !reflect.DeepEqual(products, expected) {
+			t.Errorf("got %+v, %v want %+v", products, err, expected)
+		}
+
+		stop := errors.New("client is gone")
+		calls := 0
+		err = store.EachProduct(ctx, filter, func(product *model.Product) error {
+			calls++
+			return stop
+		})
+		if err != stop || calls != 1 {
+			t.Errorf("got %v after %v calls want %v after 1", err, calls, stop)
+		}
+	})
+
+	t.Run("upsert and delete", func(t *testing.T) {
+		store := newStorage(t)
+		seller := newSeller()
+		jobId := newJob(t, store, seller)
+		err := store.SaveBatch(ctx, jobId, jobs.BatchKey{Batch: 0}, &Batch{SellerId: seller, Upsert: []*model.Product{
+			{OfferId: 1, Name: "a", Price: 10, Quantity: 1},
+			{OfferId: 2, Name: "b", Price: 20, Quantity: 2},
+		}}, &jobs.Checkpoint{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkpoint := &jobs.Checkpoint{ErrorStrings: []string{"sheet 1, row 3: price lower than zero"}}
+		err = store.SaveBatch(ctx, jobId, jobs.BatchKey{Batch: 1}, &Batch{
+			SellerId:    seller,
+			Upsert:      []*model.Product{{OfferId: 1, Name: "a2", Price: 15, Quantity: 5}},
+			Unavailable: []int64{2, 99},
+		}, checkpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checkpoint.Created != 1 || checkpoint.Deleted != 1 {
+			t.Errorf("unexpected checkpoint: %+v", checkpoint)
+		}
+
+		products := find(t, store, ProductFilter{SellerId: &seller})
+		if len(products) != 1 || products[0].Name != "a2" || products[0].Price != 15 || products[0].Quantity != 5 {
+			t.Errorf("product is not updated: %+v", products)
+		}
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		store := newStorage(t)
+		seller := newSeller()
+		save := func(batch int, changes *Batch) *jobs.Checkpoint {
+			changes.SellerId = seller
+			checkpoint := &jobs.Checkpoint{}
+			if err := store.SaveBatch(ctx, newJob(t, store, seller), jobs.BatchKey{Batch: batch}, changes, checkpoint); err != nil {
+				t.Fatal(err)
+			}
+			return checkpoint
+		}
+		save(0, &Batch{Upsert: []*model.Product{{OfferId: 1, Name: "a", Price: 10, Quantity: 1}, {OfferId: 2, Name: "b", Price: 20, Quantity: 2}}})
+		saved := find(t, store, ProductFilter{SellerId: &seller})
+		time.Sleep(10 * time.Millisecond)
+
+		//снятый с продажи товар остаётся в бд, а время изменения сдвигается, чтобы его увидела синхронизация
+		if checkpoint := save(1, &Batch{Unavailable: []int64{2, 99}}); checkpoint.Deleted != 1 {
+			t.Errorf("got %v deleted want 1", checkpoint.Deleted)
+		}
+		if products := find(t, store, ProductFilter{SellerId: &seller}); len(products) != 1 || products[0].OfferId != 1 {
+			t.Errorf("unavailable product is found: %+v", products)
+		}
+		since := saved[0].UpdatedAt.Add(time.Millisecond)
+		products := find(t, store, ProductFilter{SellerId: &seller, UpdatedSince: &since, IncludeUnavailable: true})
+		if len(products) != 1 || products[0].OfferId != 2 || products[0].Available || products[0].Name != "b" ||
+			!products[0].CreatedAt.Equal(saved[1].CreatedAt) {
+			t.Errorf("got %+v updated since %v want unavailable offer 2", products, since)
+		}
+		unavailable := products[0]
+
+		//повторное снятие не считается и не сдвигает время
+		if checkpoint := save(2, &Batch{Unavailable: []int64{2}}); checkpoint.Deleted != 0 {
+			t.Errorf("got %v deleted for unavailable product want 0", checkpoint.Deleted)
+		}
+		products = find(t, store, ProductFilter{SellerId: &seller, OfferId: &unavailable.OfferId, IncludeUnavailable: true})
+		if len(products) != 1 || !products[0].UpdatedAt.Equal(unavailable.UpdatedAt) {
+			t.Errorf("got %+v want updated_at %v", products, unavailable.UpdatedAt)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		save(3, &Batch{Upsert: []*model.Product{{OfferId: 2, Name: "b", Price: 20, Quantity: 2}}})
+		products = find(t, store, ProductFilter{SellerId: &seller, OfferId: &unavailable.OfferId})
+		if len(products) != 1 || !products[0].Available || !products[0].UpdatedAt.After(unavailable.UpdatedAt) {
+			t.Errorf("product is not available again: %+v", products)
+		}
+	})
+
+	t.Run("offer id out of integer", func(t *testing.T) {
+		store := newStorage(t)
+		seller := newSeller()
+		jobId := newJob(t, store, seller)
+		for i, batch := range []*Batch{
+			{SellerId: seller, Upsert: []*model.Product{{OfferId: 1, Name: "a", Quantity: 1}, {OfferId: 1 << 31, Name: "b", Quantity: 1}}},
+			{SellerId: seller, Unavailable: []int64{1 << 31}},
+		} {
+			if err := store.SaveBatch(ctx, jobId, jobs.BatchKey{Batch: i}, batch, &jobs.Checkpoint{}); err == nil {
+				t.Errorf("batch %v with offer id 2^31 is saved", i)
+			}
+		}
+		if products := find(t, store, ProductFilter{SellerId: &seller}); len(products) != 0 {
+			t.Errorf("rejected batch changed products: %+v", products)
+		}
+		if checkpoints, err := store.Checkpoints(ctx, jobId); err != nil || len(checkpoints) != 0 {
+			t.Errorf("got checkpoints %+v, %v for rejected batches", checkpoints, err)
+		}
+	})
+
+	t.Run("updated since", func(t *testing.T) {
+		store := newStorage(t)
+		seller := newSeller()
+		save := func(batch int, products ...*model.Product) {
+			err := store.SaveBatch(ctx, newJob(t, store, seller), jobs.BatchKey{Batch: batch}, &Batch{SellerId: seller, Upsert: products}, &jobs.Checkpoint{})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		save(0, &model.Product{OfferId: 1, Name: "a", Price: 10, Quantity: 1}, &model.Product{OfferId: 2, Name: "b", Price: 20, Quantity: 2})
+		saved := find(t, store, ProductFilter{SellerId: &seller})
+		//время в postgres хранится с точностью до микросекунд
+		time.Sleep(10 * time.Millisecond)
+
+		//тот же товар не считается изменённым, изменённый сохраняет время создания
+		save(1, &model.Product{OfferId: 1, Name: "a", Price: 10, Quantity: 1}, &model.Product{OfferId: 2, Name: "b", Price: 25, Quantity: 2})
+		products := find(t, store, ProductFilter{SellerId: &seller})
+		if !products[0].UpdatedAt.Equal(saved[0].UpdatedAt) {
+			t.Errorf("unchanged product got new updated_at: %v want %v", products[0].UpdatedAt, saved[0].UpdatedAt)
+		}
+		if !products[1].CreatedAt.Equal(saved[1].CreatedAt) || !products[1].UpdatedAt.After(saved[1].UpdatedAt) {
+			t.Errorf("changed product has wrong timestamps: %+v, was %+v", products[1], saved[1])
+		}
+
+		since := saved[0].UpdatedAt.Add(time.Millisecond)
+		products = find(t, store, ProductFilter{SellerId: &seller, UpdatedSince: &since})
+		if len(products) != 1 || products[0].OfferId != 2 {
+			t.Errorf("got %+v updated since %v want offer 2", products, since)
+		}
+		//граница включается, чтобы синхронизация по последнему полученному времени ничего не пропускала
+		since = products[0].UpdatedAt
+		if products := find(t, store, ProductFilter{SellerId: &seller, UpdatedSince: &since}); len(products) != 1 {
+			t.Errorf("got %v products updated since %v want 1", len(products), since)
+		}
+	})
+
+	t.Run("checkpoints", func(t *testing.T) {
+		store := newStorage(t)
+		seller := newSeller()
+		jobId := newJob(t, store, seller)
+		key := jobs.BatchKey{Sheet: 1, Batch: 2}
+		err := store.SaveBatch(ctx, jobId, key, &Batch{SellerId: seller, Unavailable: []int64{1}}, &jobs.Checkpoint{
+			ErrorStrings: []string{"sheet 2, row 201: offer id lower or equals zero"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkpoints, err := store.Checkpoints(ctx, jobId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkpoint, ok := checkpoints[key]
+		if len(checkpoints) != 1 || !ok || checkpoint.Deleted != 0 || len(checkpoint.ErrorStrings) != 1 {
+			t.Fatalf("unexpected checkpoints: %+v", checkpoints)
+		}
+
+		//повторная пачка не применяется целиком, вместе с изменениями товаров
+		err = store.SaveBatch(ctx, jobId, key, &Batch{SellerId: seller, Upsert: []*model.Product{
+			{OfferId: 5, Name: "e", Price: 50, Quantity: 5},
+		}}, &jobs.Checkpoint{})
+		if !errors.Is(err, ErrCheckpointExists) {
+			t.Errorf("got error %v want %v", err, ErrCheckpointExists)
+		}
+		if products := find(t, store, ProductFilter{SellerId: &seller}); len(products) != 0 {
+			t.Errorf("rejected batch changed products: %+v", products)
+		}
+
+		err = store.SaveBatch(ctx, uuid.New().String(), key, &Batch{SellerId: seller}, &jobs.Checkpoint{})
+		if !errors.Is(err, ErrUnknownJob) {
+			t.Errorf("got error %v want %v", err, ErrUnknownJob)
+		}
+	})
+
+	t.Run("jobs", func(t *testing.T) {
+		store := newStorage(t)
+		seller := newSeller()
+		first := newJob(t, store, seller)
+		second := uuid.New().String()
+		err := store.InsertJob(ctx, &StoredJob{Id: second, SellerId: seller, FilePath: "uploads/" + second + ".xlsx", BatchSize: 100, Sheets: []string{"prices", "2"}}, "new")
+		if err != nil {
+			t.Fatal(err)
+		}
+		finished := newJob(t, store, seller)
+		if err := store.SetJobStatus(ctx, first, "interrupted"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.FinishJob(ctx, finished, "finished"); err != nil {
+			t.Fatal(err)
+		}
+
+		unfinished, err := store.UnfinishedJobs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sellerJobs := []*StoredJob{}
+		for _, job := range unfinished {
+			if job.SellerId == seller {
+				sellerJobs = append(sellerJobs, job)
+			}
+		}
+		if len(sellerJobs) != 2 || sellerJobs[0].Id != first || sellerJobs[1].Id != second {
+			t.Fatalf("got unfinished jobs %+v want %v", sellerJobs, []string{first, second})
+		}
+		if sellerJobs[0].BatchSize != 100 || sellerJobs[0].FilePath != "uploads/"+first+".xlsx" {
+			t.Errorf("job fields are not stored: %+v", sellerJobs[0])
+		}
+		if len(sellerJobs[0].Sheets) != 0 || !reflect.DeepEqual(sellerJobs[1].Sheets, []string{"prices", "2"}) {
+			t.Errorf("selected sheets are not stored: %q, %q", sellerJobs[0].Sheets, sellerJobs[1].Sheets)
+		}
+
+		stored, err := store.GetJob(ctx, finished)
+		if err != nil || stored.SellerId != seller || stored.Status != "finished" || !stored.Finished {
+			t.Errorf("got job %+v, %v", stored, err)
+		}
+		for _, unknown := range []string{uuid.New().String(), "not-a-uuid"} {
+			if _, err := store.GetJob(ctx, unknown); !errors.Is(err, ErrUnknownJob) {
+				t.Errorf("%v: got error %v want %v", unknown, err, ErrUnknownJob)
+			}
+		}
+
+		//задача, созданная раньше остальных, но сохранённая последней, как задача из памяти другой копии сервиса
+		older := uuid.New().String()
+		olderCreatedAt := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+		if err := store.InsertJob(ctx, &StoredJob{Id: older, SellerId: seller, FilePath: "uploads/" + older + ".xlsx", BatchSize: 100, CreatedAt: olderCreatedAt}, "new"); err != nil {
+			t.Fatal(err)
+		}
+
+		sellerJobs, err = store.SellerJobs(ctx, seller)
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses := []string{}
+		for _, job := range sellerJobs {
+			statuses = append(statuses, fmt.Sprintf("%v %v %v", job.Id, job.Status, job.Finished))
+		}
+		expected := []string{older + " new false", first + " interrupted false", second + " new false", finished + " finished true"}
+		if !reflect.DeepEqual(statuses, expected) {
+			t.Errorf("got seller jobs %q want %q", statuses, expected)
+		}
+		if !sellerJobs[0].CreatedAt.Equal(olderCreatedAt) || sellerJobs[1].CreatedAt.IsZero() {
+			t.Errorf("got creation times %v, %v want %v and current time", sellerJobs[0].CreatedAt, sellerJobs[1].CreatedAt, olderCreatedAt)
+		}
+		if otherJobs, err := store.SellerJobs(ctx, newSeller()); err != nil || len(otherJobs) != 0 {
+			t.Errorf("got jobs of other seller %+v, %v", otherJobs, err)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"avito_test/model"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const SignatureHeader = "X-Signature-256"
+
+type Notifier struct {
+	Secret      string
+	Client      *http.Client
+	MaxAttempts int
+	BaseDelay   time.Duration
+	//разрешает callback на loopback и во внутреннюю сеть, нужно только для тестов и локальной разработки
+	AllowPrivateNetworks bool
+}
+
+func NewNotifier(secret string) *Notifier {
+	n := &Notifier{
+		Secret:      secret,
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+	}
+	//адрес проверяется при каждом подключении уже после резолва имени, так что имя,
+	//указывающее на внутренний адрес, и редирект туда тоже не пройдут
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return n.checkIP(net.ParseIP(host))
+		},
+	}
+	n.Client = &http.Client{
+		Timeout: 10 * time.Second,
+		//без прокси из окружения, иначе проверялся бы адрес прокси, а не получателя
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+	return n
+}
+
+// ErrNoSecret - без секрета получатель не может проверить подпись, поэтому callback не принимаются
+var ErrNoSecret = fmt.Errorf("callbacks are disabled: webhook secret is not set")
+
+// ValidateURL проверяет адрес callback при приёме загрузки: продавец не должен заставлять сервис
+// обращаться к его собственным портам (например, админке) и внутренней сети
+func (n *Notifier) ValidateURL(callbackUrl string) error {
+	if n.Secret == "" {
+		return ErrNoSecret
+	}
+	u, err := url.Parse(callbackUrl)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback url must be an absolute http or https url")
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if !n.AllowPrivateNetworks && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+		return fmt.Errorf("callback url must not point to a local or private address")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return n.checkIP(ip)
+	}
+	return nil
+}
+
+// sharedAddressSpace - 100.64.0.0/10, адреса провайдерского NAT
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func (n *Notifier) checkIP(ip net.IP) error {
+	if n.AllowPrivateNetworks {
+		return nil
+	}
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("callback url must not point to a local or private address")
+	}
+	return nil
+}
+
+// Sign возвращает значение заголовка подписи: hex от HMAC-SHA256 тела запроса
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send отправляет payload, повторяя попытки с экспоненциально растущей паузой,
+// каждая попытка передаётся в onAttempt. Отмена ctx прерывает и запрос, и паузу между попытками
+func (n *Notifier) Send(ctx context.Context, callbackUrl string, payload interface{}, onAttempt func(*model.DeliveryAttempt)) error {
+	//задача с callback могла быть принята до перезапуска с пустым секретом
+	if n.Secret == "" {
+		return ErrNoSecret
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	signature := Sign(n.Secret, body)
+
+	delay := n.BaseDelay
+	for attempt := 1; ; attempt++ {
+		deliveryAttempt := n.deliver(ctx, callbackUrl, body, signature)
+		deliveryAttempt.Attempt = attempt
+		onAttempt(deliveryAttempt)
+		if deliveryAttempt.Error == "" {
+			return nil
+		}
+		if attempt >= n.MaxAttempts {
+			return fmt.Errorf("callback was not delivered after %v attempts: %v", attempt, deliveryAttempt.Error)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("callback was not delivered after %v attempts: %v", attempt, ctx.Err())
+		}
+		delay *= 2
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, callbackUrl string, body []byte, signature string) *model.DeliveryAttempt {
+	deliveryAttempt := &model.DeliveryAttempt{
+		Time: time.Now(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", callbackUrl, bytes.NewReader(body))
+	if err != nil {
+		deliveryAttempt.Error = err.Error()
+		return deliveryAttempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, signature)
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		deliveryAttempt.Error = err.Error()
+		return deliveryAttempt
+	}
+	resp.Body.Close()
+
+	deliveryAttempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		deliveryAttempt.Error = fmt.Sprintf("unexpected status code: %v", resp.StatusCode)
+	}
+	return deliveryAttempt
+}
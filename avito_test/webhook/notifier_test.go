@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"avito_test/model"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendRetriesUntilDelivered(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+			t.Errorf("wrong signature: %v", r.Header.Get(SignatureHeader))
+		}
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := NewNotifier("secret")
+	n.BaseDelay = time.Millisecond
+	n.AllowPrivateNetworks = true
+	attempts := []*model.DeliveryAttempt{}
+	err := n.Send(context.Background(), server.URL, map[string]string{"Status": "finished"}, func(attempt *model.DeliveryAttempt) {
+		attempts = append(attempts, attempt)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(attempts) != 3 {
+		t.Fatalf("got %v attempts want 3", len(attempts))
+	}
+	if attempts[0].StatusCode != http.StatusServiceUnavailable || attempts[0].Error == "" {
+		t.Errorf("unexpected first attempt: %+v", attempts[0])
+	}
+	if attempts[2].StatusCode != http.StatusOK || attempts[2].Error != "" || attempts[2].Attempt != 3 {
+		t.Errorf("unexpected last attempt: %+v", attempts[2])
+	}
+}
+
+func TestSendGivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n := NewNotifier("secret")
+	n.BaseDelay = time.Millisecond
+	n.AllowPrivateNetworks = true
+	n.MaxAttempts = 2
+	attempts := 0
+	err := n.Send(context.Background(), server.URL, nil, func(*model.DeliveryAttempt) {
+		attempts++
+	})
+	if err == nil {
+		t.Errorf("expected error after all attempts failed")
+	}
+	if attempts != 2 {
+		t.Errorf("got %v attempts want 2", attempts)
+	}
+}
+
+// TestSendStopsOnCancel проверяет, что отмена контекста при остановке сервиса не ждёт паузу между попытками
+func TestSendStopsOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n := NewNotifier("secret")
+	n.BaseDelay = time.Hour
+	n.AllowPrivateNetworks = true
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	sent := make(chan error)
+	go func() {
+		sent <- n.Send(ctx, server.URL, nil, func(*model.DeliveryAttempt) {
+			attempts++
+			cancel()
+		})
+	}()
+	select {
+	case err := <-sent:
+		if err == nil || !strings.Contains(err.Error(), "context canceled") || attempts != 1 {
+			t.Errorf("got %v after %v attempts", err, attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send did not stop after cancel")
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	n := NewNotifier("secret")
+	for callbackUrl, valid := range map[string]bool{
+		"https://example.com/hook":            true,
+		"http://93.184.216.34/hook":           true,
+		"ftp://example.com":                   false,
+		"/relative/path":                      false,
+		"://broken":                           false,
+		"http://localhost:6060/admin/status":  false,
+		"http://LOCALHOST.:8081":              false,
+		"http://api.localhost":                false,
+		"http://127.0.0.1:6060/debug/pprof/":  false,
+		"http://[::1]:8080":                   false,
+		"http://[::ffff:127.0.0.1]":           false,
+		"http://0.0.0.0":                      false,
+		"http://10.1.2.3":                     false,
+		"http://172.16.0.1":                   false,
+		"http://192.168.1.1":                  false,
+		"http://169.254.169.254/latest/meta/": false,
+		"http://100.64.0.1":                   false,
+		"http://[fd00::1]":                    false,
+	} {
+		if err := n.ValidateURL(callbackUrl); (err == nil) != valid {
+			t.Errorf("ValidateURL(%v) returned %v", callbackUrl, err)
+		}
+	}
+
+	n.AllowPrivateNetworks = true
+	if err := n.ValidateURL("http://localhost:8081"); err != nil {
+		t.Errorf("local url is rejected with AllowPrivateNetworks: %v", err)
+	}
+
+	n.Secret = ""
+	if err := n.ValidateURL("https://example.com/hook"); err != ErrNoSecret {
+		t.Errorf("callback is accepted without secret: %v", err)
+	}
+}
+
+// TestSendRefusesPrivateAddress проверяет адрес при подключении: имя могло резолвиться во внутренний адрес
+func TestSendRefusesPrivateAddress(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	n := NewNotifier("secret")
+	n.BaseDelay = time.Millisecond
+	n.MaxAttempts = 1
+	var attempt *model.DeliveryAttempt
+	err := n.Send(context.Background(), strings.Replace(server.URL, "127.0.0.1", "localhost", 1), nil, func(a *model.DeliveryAttempt) {
+		attempt = a
+	})
+	if err == nil || requests != 0 || !strings.Contains(attempt.Error, "local or private address") {
+		t.Errorf("got %v, %v requests, attempt %+v", err, requests, attempt)
+	}
+}